@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Asset is a CSS or JS bundle written to public/assets with a content hash
+// in its file name. It prints as its URL in templates.
+type Asset struct {
+	Name         string
+	RelPermalink string
+	URL          string
+	Integrity    string
+}
+
+func (a Asset) String() string {
+	return a.URL
+}
+
+// Assets holds bundles built from files in the assets folder.
+type Assets struct {
+	bundles map[string]Asset
+	outputs []Output
+}
+
+// buildAssets concatenates and minifies every bundle from config. Files are
+// read from the project's assets folder and must match the bundle type.
+func buildAssets(projectRoot string, bundles []ConfigAsset) (*Assets, error) {
+	assets := &Assets{bundles: map[string]Asset{}}
+	m := newMinifier()
+
+	for _, bundle := range bundles {
+		ext := path.Ext(bundle.Name)
+		mediaType, separator := "", ""
+		switch ext {
+		case ".css":
+			mediaType, separator = "text/css", "\n"
+		case ".js":
+			// Guards against files that do not end their last statement.
+			mediaType, separator = "application/js", ";\n"
+		default:
+			return nil, fmt.Errorf("bundle %q: name must end with .css or .js", bundle.Name)
+		}
+		if _, ok := assets.bundles[bundle.Name]; ok {
+			return nil, fmt.Errorf("bundle %q is defined more than once", bundle.Name)
+		}
+		if len(bundle.Files) == 0 {
+			return nil, fmt.Errorf("bundle %q: no files", bundle.Name)
+		}
+
+		var content bytes.Buffer
+		for i, file := range bundle.Files {
+			if path.Ext(file) != ext {
+				return nil, fmt.Errorf("bundle %q: %s is not a %s file", bundle.Name, file, ext)
+			}
+			source, err := os.ReadFile(path.Join(projectRoot, "assets", file))
+			if err != nil {
+				return nil, fmt.Errorf("bundle %q: %w", bundle.Name, err)
+			}
+			if i > 0 {
+				content.WriteString(separator)
+			}
+			content.Write(source)
+		}
+
+		minified, err := m.Bytes(mediaType, content.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("bundle %q: minifying: %w", bundle.Name, err)
+		}
+
+		hash := sha256.Sum256(minified)
+		integrity := sha512.Sum384(minified)
+		name := strings.TrimSuffix(path.Clean("/"+bundle.Name), ext)
+		relPermalink := path.Join("assets", name+"."+hex.EncodeToString(hash[:])[:12]+ext)
+
+		assets.bundles[bundle.Name] = Asset{
+			Name:         bundle.Name,
+			RelPermalink: relPermalink,
+			URL:          "/" + relPermalink,
+			Integrity:    "sha384-" + base64.StdEncoding.EncodeToString(integrity[:]),
+		}
+		assets.outputs = append(assets.outputs, Output{
+			URL:     relPermalink,
+			Content: minified,
+			Source:  fmt.Sprintf("assets bundle %q", bundle.Name),
+		})
+	}
+
+	return assets, nil
+}
+
+// Lookup returns a bundle by its name in config. Used as the `asset` filter.
+func (a *Assets) Lookup(name string) (Asset, error) {
+	asset, ok := a.bundles[name]
+	if !ok {
+		return Asset{}, fmt.Errorf("asset %q is not a bundle in config.yaml", name)
+	}
+	return asset, nil
+}
+
+// Outputs returns the minified bundles to write into public.
+func (a *Assets) Outputs() []Output {
+	return a.outputs
+}
+
+// Salt returns the URLs of all bundles. Shortcodes and render hooks can link
+// assets, so converted markdown depends on them.
+func (a *Assets) Salt() []byte {
+	var salt bytes.Buffer
+	for _, output := range a.outputs {
+		salt.WriteString(output.URL)
+		salt.WriteByte(0)
+	}
+	return salt.Bytes()
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Bump when the layout of cached entries or the conversion pipeline changes
+// in a way that makes old entries invalid.
+const cacheVersion = "9"
+
+// BuildCache stores converted markdown between builds in .jbmafp/cache so
+// unchanged pages skip goldmark and TextRank.
+type BuildCache struct {
+	dir  string
+	salt []byte
+
+	mu   sync.Mutex
+	used map[string]bool
+}
+
+// cachedPage is everything expensive to compute for a page. Front matter
+// is not cached, it is parsed from the source on every build so its values
+// keep the types YAML gives them.
+type cachedPage struct {
+	HTML        string      `json:"html"`
+	Text        string      `json:"text"`
+	Summary     string      `json:"summary"`
+	SummaryHTML string      `json:"summary_html"`
+	TOC         []*TOCEntry `json:"toc"`
+}
+
+// openBuildCache creates the cache folder. Salt is everything besides the
+// markdown source that influences conversion (config, templates used while
+// converting) and is mixed into every key.
+func openBuildCache(projectRoot string, salt ...[]byte) (*BuildCache, error) {
+	dir := path.Join(projectRoot, ".jbmafp", "cache")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	h.Write([]byte(cacheVersion))
+	for _, s := range salt {
+		h.Write(s)
+	}
+
+	return &BuildCache{
+		dir:  dir,
+		salt: h.Sum(nil),
+		used: map[string]bool{},
+	}, nil
+}
+
+// cacheSalt collects project files that change how markdown converts, such
+// as shortcode templates, for openBuildCache. Files are written sorted by
+// name, each with its name in front, so renaming a file changes the salt
+// and the order files were added in does not.
+type cacheSalt struct {
+	files map[string][]byte
+}
+
+// AddFile adds a file to the salt.
+func (s *cacheSalt) AddFile(name string, content []byte) {
+	if s.files == nil {
+		s.files = map[string][]byte{}
+	}
+	s.files[name] = content
+}
+
+// Bytes returns the salt, empty when no files were added.
+func (s *cacheSalt) Bytes() []byte {
+	names := make([]string, 0, len(s.files))
+	for name := range s.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var salt bytes.Buffer
+	for _, name := range names {
+		salt.WriteString(name)
+		salt.WriteByte(0)
+		salt.Write(s.files[name])
+		salt.WriteByte(0)
+	}
+	return salt.Bytes()
+}
+
+// Key returns the cache key of a markdown source and anything else the
+// conversion depends on.
+func (c *BuildCache) Key(parts ...[]byte) string {
+	h := sha256.New()
+	h.Write(c.salt)
+	for _, part := range parts {
+		h.Write(part)
+		h.Write([]byte{0})
+	}
+	key := hex.EncodeToString(h.Sum(nil))
+
+	c.mu.Lock()
+	c.used[key] = true
+	c.mu.Unlock()
+
+	return key
+}
+
+// Get loads a cached entry. Broken entries are treated as misses.
+func (c *BuildCache) Get(key string) (cachedPage, bool) {
+	var entry cachedPage
+	content, err := os.ReadFile(path.Join(c.dir, key+".json"))
+	if err != nil {
+		return entry, false
+	}
+	if err := json.Unmarshal(content, &entry); err != nil {
+		return entry, false
+	}
+	return entry, true
+}
+
+// Put stores an entry under key.
+func (c *BuildCache) Put(key string, entry cachedPage) error {
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(c.dir, key+".json"), content, 0644)
+}
+
+// Prune removes entries that were not used by this build.
+func (c *BuildCache) Prune() error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, entry := range entries {
+		key := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		if !c.used[key] {
+			os.Remove(path.Join(c.dir, entry.Name()))
+		}
+	}
+	return nil
+}
+
+// writeIfChanged writes content to filename unless the file already holds
+// exactly the same bytes. Reports whether the file was written.
+func writeIfChanged(filename string, content []byte) (bool, error) {
+	if existing, err := os.ReadFile(filename); err == nil && string(existing) == string(content) {
+		return false, nil
+	}
+	return true, os.WriteFile(filename, content, 0755)
+}
+
+// skipUnchangedStatic skips static files whose copy in public already has
+// the same size and modification time.
+func skipUnchangedStatic(srcinfo os.FileInfo, src, dest string) (bool, error) {
+	if srcinfo.IsDir() {
+		return false, nil
+	}
+	destinfo, err := os.Stat(dest)
+	if err != nil {
+		return false, nil
+	}
+	return destinfo.Size() == srcinfo.Size() && destinfo.ModTime().Equal(srcinfo.ModTime()), nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var urlAttrPattern = regexp.MustCompile(`(?i)(\s(?:href|src|poster|srcset)\s*=\s*)("[^"]*"|'[^']*')`)
+
+// Page (or feed) update time, falls back to creation when lastmod is older.
+func pageUpdated(page Page) time.Time {
+	if page.Lastmod.After(page.Created) {
+		return page.Lastmod
+	}
+	return page.Created
+}
+
+// feedPages returns published pages of the feed types limited to feed limit.
+// Drafts, scheduled and expired pages kept for previewing are left out.
+func feedPages(feed ConfigFeed, pages []Page) []Page {
+	items := []Page{}
+	for _, page := range pagesOfTypes(pages, feed.Type) {
+		if page.Draft || page.Status != "" {
+			continue
+		}
+		items = append(items, page)
+	}
+	if feed.Limit > 0 && len(items) > feed.Limit {
+		items = items[:feed.Limit]
+	}
+	return items
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr,omitempty"`
+	Body string `xml:",chardata"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomEntry struct {
+	Base       string         `xml:"xml:base,attr,omitempty"`
+	ID         string         `xml:"id"`
+	Title      atomText       `xml:"title"`
+	Link       atomLink       `xml:"link"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Categories []atomCategory `xml:"category"`
+	Summary    *atomText      `xml:"summary,omitempty"`
+	Content    atomText       `xml:"content"`
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"feed"`
+	Xmlns    string      `xml:"xmlns,attr"`
+	Lang     string      `xml:"xml:lang,attr,omitempty"`
+	Base     string      `xml:"xml:base,attr,omitempty"`
+	ID       string      `xml:"id"`
+	Title    atomText    `xml:"title"`
+	Subtitle *atomText   `xml:"subtitle,omitempty"`
+	Links    []atomLink  `xml:"link"`
+	Updated  string      `xml:"updated"`
+	Author   atomPerson  `xml:"author"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+// buildAtomFeed renders an Atom 1.0 feed. Content is escaped by the XML
+// encoder, relative links in it resolve against the xml:base of its entry.
+func buildAtomFeed(config Config, feed ConfigFeed, pages []Page) ([]byte, error) {
+	homeURL := absoluteURL(config.BaseURL, "")
+	out := atomFeed{
+		Xmlns: "http://www.w3.org/2005/Atom",
+		Lang:  config.Language,
+		Base:  homeURL,
+		ID:    homeURL,
+		Title: atomText{Type: "text", Body: feedTitle(config, feed)},
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: absoluteURL(config.BaseURL, feed.URL)},
+			{Rel: "alternate", Type: "text/html", Href: homeURL},
+		},
+		Author: atomPerson{Name: feedAuthor(config, feed), URI: homeURL},
+	}
+	if config.Description != "" {
+		out.Subtitle = &atomText{Type: "text", Body: config.Description}
+	}
+
+	var updated time.Time
+	for _, page := range feedPages(feed, pages) {
+		pageURL := absoluteURL(config.BaseURL, page.RelPermalink)
+		entry := atomEntry{
+			Base:      pageURL,
+			ID:        pageURL,
+			Title:     atomText{Type: "text", Body: page.Title},
+			Link:      atomLink{Rel: "alternate", Type: "text/html", Href: pageURL},
+			Published: page.Created.Format(time.RFC3339),
+			Updated:   pageUpdated(page).Format(time.RFC3339),
+			Content:   atomText{Type: "html", Body: page.Raw},
+		}
+		if page.Summary != "" {
+			entry.Summary = &atomText{Type: "text", Body: page.Summary}
+		}
+		for _, tag := range page.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: tag})
+		}
+		if pageUpdated(page).After(updated) {
+			updated = pageUpdated(page)
+		}
+		out.Entries = append(out.Entries, entry)
+	}
+
+	// Empty feeds still need a valid updated element.
+	if updated.IsZero() {
+		updated = time.Unix(0, 0).UTC()
+	}
+	out.Updated = updated.Format(time.RFC3339)
+
+	content, err := xml.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), content...), nil
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+	URL  string `json:"url,omitempty"`
+}
+
+type jsonFeedItem struct {
+	ID            string   `json:"id"`
+	URL           string   `json:"url"`
+	Title         string   `json:"title"`
+	ContentHTML   string   `json:"content_html"`
+	Summary       string   `json:"summary,omitempty"`
+	DatePublished string   `json:"date_published"`
+	DateModified  string   `json:"date_modified"`
+	Tags          []string `json:"tags,omitempty"`
+}
+
+type jsonFeed struct {
+	Version     string           `json:"version"`
+	Title       string           `json:"title"`
+	HomePageURL string           `json:"home_page_url"`
+	FeedURL     string           `json:"feed_url"`
+	Description string           `json:"description,omitempty"`
+	Language    string           `json:"language,omitempty"`
+	Authors     []jsonFeedAuthor `json:"authors"`
+	Items       []jsonFeedItem   `json:"items"`
+}
+
+// absoluteLinks resolves relative href, src, poster and srcset URLs in
+// HTML against pageURL. Used where there is no base to resolve them.
+func absoluteLinks(htmlContent string, pageURL string) string {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return htmlContent
+	}
+	resolve := func(value string) string {
+		ref, err := url.Parse(value)
+		if err != nil {
+			return value
+		}
+		return base.ResolveReference(ref).String()
+	}
+
+	return urlAttrPattern.ReplaceAllStringFunc(htmlContent, func(attr string) string {
+		m := urlAttrPattern.FindStringSubmatch(attr)
+		quote := m[2][:1]
+		value := html.UnescapeString(m[2][1 : len(m[2])-1])
+
+		if strings.Contains(strings.ToLower(m[1]), "srcset") {
+			candidates := strings.Split(value, ",")
+			for i, candidate := range candidates {
+				fields := strings.Fields(candidate)
+				if len(fields) > 0 {
+					fields[0] = resolve(fields[0])
+				}
+				candidates[i] = strings.Join(fields, " ")
+			}
+			value = strings.Join(candidates, ", ")
+		} else {
+			value = resolve(strings.TrimSpace(value))
+		}
+		return m[1] + quote + html.EscapeString(value) + quote
+	})
+}
+
+// buildJSONFeed renders a JSON Feed 1.1. It has no base URL, so links in
+// content are made absolute.
+func buildJSONFeed(config Config, feed ConfigFeed, pages []Page) ([]byte, error) {
+	homeURL := absoluteURL(config.BaseURL, "")
+	out := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       feedTitle(config, feed),
+		HomePageURL: homeURL,
+		FeedURL:     absoluteURL(config.BaseURL, feed.URL),
+		Description: config.Description,
+		Language:    config.Language,
+		Authors:     []jsonFeedAuthor{{Name: feedAuthor(config, feed), URL: homeURL}},
+		Items:       []jsonFeedItem{},
+	}
+
+	for _, page := range feedPages(feed, pages) {
+		pageURL := absoluteURL(config.BaseURL, page.RelPermalink)
+		out.Items = append(out.Items, jsonFeedItem{
+			ID:            pageURL,
+			URL:           pageURL,
+			Title:         page.Title,
+			ContentHTML:   absoluteLinks(page.Raw, pageURL),
+			Summary:       page.Summary,
+			DatePublished: page.Created.Format(time.RFC3339),
+			DateModified:  pageUpdated(page).Format(time.RFC3339),
+			Tags:          page.Tags,
+		})
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(out); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func feedTitle(config Config, feed ConfigFeed) string {
+	if feed.Title != "" {
+		return feed.Title
+	}
+	return config.Title
+}
+
+func feedAuthor(config Config, feed ConfigFeed) string {
+	if feed.Author != "" {
+		return feed.Author
+	}
+	return config.Title
+}
+
+// buildFeed renders a feed in the configured format.
+func buildFeed(config Config, feed ConfigFeed, pages []Page) ([]byte, error) {
+	switch feed.Format {
+	case "atom":
+		return buildAtomFeed(config, feed, pages)
+	case "json":
+		return buildJSONFeed(config, feed, pages)
+	default:
+		return nil, fmt.Errorf("unknown feed format %q, use atom or json", feed.Format)
+	}
+}
@@ -1,10 +1,11 @@
 module mitjafelicijan.com/jbmafp
 
-go 1.20
+go 1.23
 
 require (
 	github.com/DavidBelicza/TextRank/v2 v2.1.3
 	github.com/alexflint/go-arg v1.4.3
+	github.com/gen2brain/webp v0.5.5
 	github.com/gosimple/slug v1.13.1
 	github.com/mangoumbrella/goldmark-figure v1.0.0
 	github.com/microcosm-cc/bluemonday v1.0.24
@@ -13,6 +14,7 @@ require (
 	github.com/yuin/goldmark v1.5.4
 	github.com/yuin/goldmark-highlighting/v2 v2.0.0-20220924101305-151362477c87
 	github.com/yuin/goldmark-meta v1.1.0
+	gopkg.in/yaml.v2 v2.3.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
@@ -21,10 +23,11 @@ require (
 	github.com/alexflint/go-scalar v1.1.0 // indirect
 	github.com/aymerick/douceur v0.2.0 // indirect
 	github.com/dlclark/regexp2 v1.7.0 // indirect
+	github.com/ebitengine/purego v0.8.3 // indirect
 	github.com/gorilla/css v1.0.0 // indirect
 	github.com/gosimple/unidecode v1.0.1 // indirect
 	github.com/tdewolff/parse/v2 v2.6.6 // indirect
+	github.com/tetratelabs/wazero v1.9.0 // indirect
 	golang.org/x/net v0.10.0 // indirect
 	golang.org/x/sys v0.8.0 // indirect
-	gopkg.in/yaml.v2 v2.3.0 // indirect
 )
@@ -0,0 +1,664 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"html/template"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"math"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gen2brain/webp"
+)
+
+// Bump when resizing or encoding changes so cached variants are redone.
+const imageCacheVersion = "1"
+
+// Quality of JPEG variants when config does not set one.
+const defaultImageQuality = 80
+
+var imgTagPattern = regexp.MustCompile(`<img\b[^>]*>`)
+var imgAttrPattern = regexp.MustCompile(`\s([A-Za-z-]+)(?:\s*=\s*("[^"]*"|'[^']*'|[^\s"'>]+))?`)
+
+// ImageProcessor makes resized variants of JPEG and PNG images from static
+// that pages reference. The largest variant replaces the original in public
+// so neither the full size file nor its EXIF get published. With webp on,
+// every variant gets a WebP copy as well. Variants are cached in
+// .jbmafp/images by content.
+type ImageProcessor struct {
+	projectRoot string
+	config      ConfigImages
+	baseURL     string
+	dir         string
+
+	mu     sync.Mutex
+	images map[string]*processedImage
+	used   map[string]bool
+}
+
+// processedImage is a static image with its variants in ascending width.
+// The last variant is published under the original name. WebP variants
+// have the same widths and are empty unless webp is on.
+type processedImage struct {
+	once     sync.Once
+	err      error
+	variants []imageVariant
+	webp     []imageVariant
+}
+
+type imageVariant struct {
+	URL    string
+	Width  int
+	Height int
+	file   string
+}
+
+// newImageProcessor creates the image cache folder.
+func newImageProcessor(projectRoot string, config Config) (*ImageProcessor, error) {
+	dir := path.Join(projectRoot, ".jbmafp", "images")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	imagesConfig := config.Images
+	if imagesConfig.Quality <= 0 || imagesConfig.Quality > 100 {
+		imagesConfig.Quality = defaultImageQuality
+	}
+
+	return &ImageProcessor{
+		projectRoot: projectRoot,
+		config:      imagesConfig,
+		baseURL:     strings.TrimSuffix(config.BaseURL, "/"),
+		dir:         dir,
+		images:      map[string]*processedImage{},
+		used:        map[string]bool{},
+	}, nil
+}
+
+// RewritePage adds width, height and srcset to images in page content.
+// Relative sources are resolved against the folder of the page.
+func (p *ImageProcessor) RewritePage(page *Page) error {
+	dir := "/" + page.RelPermalink
+	if !strings.HasSuffix(dir, "/") {
+		dir = path.Dir(dir)
+	}
+
+	content, err := p.Rewrite(string(page.HTML), dir)
+	if err != nil {
+		return err
+	}
+	summary, err := p.Rewrite(string(page.SummaryHTML), dir)
+	if err != nil {
+		return err
+	}
+
+	page.Raw = content
+	page.HTML = template.HTML(content)
+	page.SummaryHTML = template.HTML(summary)
+	return nil
+}
+
+// Rewrite adds width, height and srcset to every <img> pointing to a JPEG
+// or PNG in static, relative sources are resolved against dir. With webp on
+// the image is wrapped in a <picture> with a WebP <source>, or gets the
+// source put in front of it when it already is in one. Images that already
+// have a srcset are left alone.
+func (p *ImageProcessor) Rewrite(htmlContent string, dir string) (string, error) {
+	var firstErr error
+	var result strings.Builder
+	last := 0
+	for _, loc := range imgTagPattern.FindAllStringIndex(htmlContent, -1) {
+		tag := htmlContent[loc[0]:loc[1]]
+		result.WriteString(htmlContent[last:loc[0]])
+		last = loc[1]
+
+		attrs := map[string]string{}
+		for _, m := range imgAttrPattern.FindAllStringSubmatch(tag, -1) {
+			attrs[strings.ToLower(m[1])] = html.UnescapeString(strings.Trim(m[2], `"'`))
+		}
+		if _, ok := attrs["srcset"]; ok {
+			result.WriteString(tag)
+			continue
+		}
+
+		src := attrs["src"]
+		rel := p.staticPath(src, dir)
+		if rel == "" {
+			result.WriteString(tag)
+			continue
+		}
+		img, err := p.process(rel)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			result.WriteString(tag)
+			continue
+		}
+
+		// Variants are linked the way src is written: relative, absolute or
+		// with baseurl, only the file name changes.
+		prefix := src
+		if idx := strings.IndexAny(prefix, "?#"); idx >= 0 {
+			prefix = prefix[:idx]
+		}
+		prefix = prefix[:strings.LastIndex(prefix, "/")+1]
+
+		variants := img.variants
+		largest := variants[len(variants)-1]
+		var extra strings.Builder
+		if _, ok := attrs["width"]; !ok {
+			if _, ok := attrs["height"]; !ok {
+				fmt.Fprintf(&extra, ` width="%d" height="%d"`, largest.Width, largest.Height)
+			}
+		}
+		if len(variants) > 1 {
+			fmt.Fprintf(&extra, ` srcset="%s"`, html.EscapeString(p.srcset(prefix, variants)))
+			if p.config.Sizes != "" {
+				fmt.Fprintf(&extra, ` sizes="%s"`, html.EscapeString(p.config.Sizes))
+			}
+		}
+
+		// Keep the tag closed the way it was, `/>` or `>`.
+		head := strings.TrimSuffix(tag, ">")
+		tail := ">"
+		if strings.HasSuffix(head, "/") {
+			head = strings.TrimSuffix(head, "/")
+			tail = " />"
+		}
+		tag = strings.TrimRight(head, " \t\n") + extra.String() + tail
+
+		if len(img.webp) == 0 {
+			result.WriteString(tag)
+			continue
+		}
+
+		source := fmt.Sprintf(`<source type="image/webp" srcset="%s"`, html.EscapeString(p.srcset(prefix, img.webp)))
+		if len(img.webp) > 1 && p.config.Sizes != "" {
+			source += fmt.Sprintf(` sizes="%s"`, html.EscapeString(p.config.Sizes))
+		}
+		source += ">"
+
+		// Inside a <picture> the source goes right before the image, unless
+		// the picture already offers WebP.
+		before := strings.ToLower(htmlContent[:loc[0]])
+		open := strings.LastIndex(before, "<picture")
+		switch {
+		case open < 0 || open < strings.LastIndex(before, "</picture"):
+			result.WriteString("<picture>" + source + tag + "</picture>")
+		case strings.Contains(before[open:], "image/webp"):
+			result.WriteString(tag)
+		default:
+			result.WriteString(source + tag)
+		}
+	}
+	result.WriteString(htmlContent[last:])
+	return result.String(), firstErr
+}
+
+// srcset lists variants with their widths, linked with prefix in front of
+// the file name. A single variant is listed without width.
+func (p *ImageProcessor) srcset(prefix string, variants []imageVariant) string {
+	if len(variants) == 1 {
+		return prefix + path.Base(variants[0].URL)
+	}
+	srcset := make([]string, len(variants))
+	for i, variant := range variants {
+		srcset[i] = fmt.Sprintf("%s%s %dw", prefix, path.Base(variant.URL), variant.Width)
+	}
+	return strings.Join(srcset, ", ")
+}
+
+// staticPath returns the path in static a src points to, or an empty string
+// for anything that is not a JPEG or PNG there. Src can be absolute, start
+// with baseurl or be relative to dir.
+func (p *ImageProcessor) staticPath(src string, dir string) string {
+	if p.baseURL != "" && strings.HasPrefix(src, p.baseURL+"/") {
+		src = strings.TrimPrefix(src, p.baseURL)
+	}
+	if idx := strings.IndexAny(src, "?#"); idx >= 0 {
+		src = src[:idx]
+	}
+	if u, err := url.Parse(src); err != nil || u.Scheme != "" || strings.HasPrefix(src, "//") {
+		return ""
+	}
+	if !strings.HasPrefix(src, "/") {
+		src = path.Join(dir, src)
+	}
+
+	rel := strings.TrimPrefix(path.Clean("/"+src), "/")
+	switch strings.ToLower(path.Ext(rel)) {
+	case ".jpg", ".jpeg", ".png":
+	default:
+		return ""
+	}
+
+	info, err := os.Stat(path.Join(p.projectRoot, "static", rel))
+	if err != nil || !info.Mode().IsRegular() {
+		return ""
+	}
+	return rel
+}
+
+// process makes variants of an image once per build.
+func (p *ImageProcessor) process(rel string) (*processedImage, error) {
+	p.mu.Lock()
+	img, ok := p.images[rel]
+	if !ok {
+		img = &processedImage{}
+		p.images[rel] = img
+	}
+	p.mu.Unlock()
+
+	img.once.Do(func() {
+		img.variants, img.webp, img.err = p.processImage(rel)
+		if img.err != nil {
+			img.err = fmt.Errorf("static/%s: %w", rel, img.err)
+		}
+	})
+	return img, img.err
+}
+
+// variantWidths returns widths to make for an image of the given width:
+// every configured width below it, and the largest one capped to it.
+func (p *ImageProcessor) variantWidths(width int) []int {
+	widths := []int{}
+	largest := 0
+	for _, w := range p.config.Widths {
+		if w <= 0 {
+			continue
+		}
+		if w < width {
+			widths = append(widths, w)
+		}
+		if w > largest {
+			largest = w
+		}
+	}
+	if largest > width {
+		largest = width
+	}
+	widths = append(widths, largest)
+
+	sort.Ints(widths)
+	unique := widths[:1]
+	for _, w := range widths[1:] {
+		if w != unique[len(unique)-1] {
+			unique = append(unique, w)
+		}
+	}
+	return unique
+}
+
+func (p *ImageProcessor) processImage(rel string) ([]imageVariant, []imageVariant, error) {
+	content, err := os.ReadFile(path.Join(p.projectRoot, "static", rel))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil, nil, fmt.Errorf("decoding image: %w", err)
+	}
+	if format != "jpeg" && format != "png" {
+		return nil, nil, fmt.Errorf("unsupported image format %q", format)
+	}
+
+	orientation := 1
+	if format == "jpeg" {
+		orientation = jpegOrientation(content)
+	}
+	width, height := cfg.Width, cfg.Height
+	if orientation >= 5 {
+		width, height = height, width
+	}
+
+	ext := path.Ext(rel)
+	base := strings.TrimSuffix(rel, ext)
+	widths := p.variantWidths(width)
+
+	// Decoded only when some variant is not cached yet.
+	var decoded *image.RGBA
+
+	// encode makes one variant in the given format unless it is cached.
+	encode := func(w, h int, url string, encoding string) (imageVariant, error) {
+		hash := sha256.New()
+		fmt.Fprintf(hash, "%s\x00%s\x00%s\x00%d\x00%d\x00", imageCacheVersion, format, encoding, w, p.config.Quality)
+		hash.Write(content)
+		key := hex.EncodeToString(hash.Sum(nil))
+		file := path.Join(p.dir, key+"."+encoding)
+
+		p.mu.Lock()
+		p.used[key] = true
+		p.mu.Unlock()
+
+		variant := imageVariant{URL: url, Width: w, Height: h, file: file}
+		if _, err := os.Stat(file); err == nil {
+			return variant, nil
+		}
+
+		if decoded == nil {
+			src, _, err := image.Decode(bytes.NewReader(content))
+			if err != nil {
+				return variant, fmt.Errorf("decoding image: %w", err)
+			}
+			decoded = orientImage(toRGBA(src), orientation)
+		}
+
+		resized := decoded
+		if w != decoded.Bounds().Dx() {
+			resized = resizeImage(decoded, w, h)
+		}
+
+		var buf bytes.Buffer
+		var err error
+		switch {
+		case encoding == "webp":
+			// PNGs are mostly drawings and screenshots, lossy WebP smears them.
+			err = webp.Encode(&buf, resized, webp.Options{Quality: p.config.Quality, Lossless: format == "png"})
+		case format == "jpeg":
+			err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: p.config.Quality})
+		default:
+			err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, resized)
+		}
+		if err != nil {
+			return variant, fmt.Errorf("encoding %dpx %s variant: %w", w, encoding, err)
+		}
+		if err := os.WriteFile(file, buf.Bytes(), 0644); err != nil {
+			return variant, err
+		}
+		log.Printf("Resized static/%s to %dx%d %s\n", rel, w, h, encoding)
+		return variant, nil
+	}
+
+	variants := make([]imageVariant, len(widths))
+	var webpVariants []imageVariant
+	for i, w := range widths {
+		h := int(math.Round(float64(height) * float64(w) / float64(width)))
+		if h < 1 {
+			h = 1
+		}
+
+		url := "/" + rel
+		webpURL := "/" + base + ".webp"
+		if i < len(widths)-1 {
+			url = fmt.Sprintf("/%s-%dw%s", base, w, ext)
+			webpURL = fmt.Sprintf("/%s-%dw.webp", base, w)
+		}
+
+		variants[i], err = encode(w, h, url, format)
+		if err != nil {
+			return nil, nil, err
+		}
+		if p.config.WebP {
+			variant, err := encode(w, h, webpURL, "webp")
+			if err != nil {
+				return nil, nil, err
+			}
+			webpVariants = append(webpVariants, variant)
+		}
+	}
+
+	return variants, webpVariants, nil
+}
+
+// Processed reports whether a static file is published through variants
+// instead of being copied.
+func (p *ImageProcessor) Processed(rel string) bool {
+	p.mu.Lock()
+	img, ok := p.images[filepath.ToSlash(rel)]
+	p.mu.Unlock()
+	return ok && img.err == nil && len(img.variants) > 0
+}
+
+// Outputs returns variants of every processed image, WebP ones included,
+// read from the cache.
+func (p *ImageProcessor) Outputs() ([]Output, error) {
+	p.mu.Lock()
+	rels := make([]string, 0, len(p.images))
+	for rel := range p.images {
+		rels = append(rels, rel)
+	}
+	p.mu.Unlock()
+	sort.Strings(rels)
+
+	var outputs []Output
+	for _, rel := range rels {
+		if !p.Processed(rel) {
+			continue
+		}
+		img := p.images[rel]
+		for _, variant := range append(append([]imageVariant{}, img.variants...), img.webp...) {
+			content, err := os.ReadFile(variant.file)
+			if err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, Output{
+				URL:     strings.TrimPrefix(variant.URL, "/"),
+				Content: content,
+				Source:  path.Join("static", rel),
+			})
+		}
+	}
+	return outputs, nil
+}
+
+// Prune removes cached variants that were not used by this build.
+func (p *ImageProcessor) Prune() error {
+	entries, err := os.ReadDir(p.dir)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, entry := range entries {
+		key := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		if !p.used[key] {
+			os.Remove(path.Join(p.dir, entry.Name()))
+		}
+	}
+	return nil
+}
+
+// toRGBA converts any decoded image to premultiplied RGBA so averaging
+// pixels handles transparency right.
+func toRGBA(src image.Image) *image.RGBA {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Src)
+	return dst
+}
+
+// orientImage turns an image upright according to its EXIF orientation,
+// which is dropped together with the rest of EXIF when re-encoding.
+func orientImage(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(x, y):src.PixOffset(x, y)+4])
+		}
+	}
+	return dst
+}
+
+// contribution is the share a source pixel has in a target pixel.
+type contribution struct {
+	index  int
+	weight float64
+}
+
+// areaWeights maps every target pixel to the source pixels it covers,
+// weighted by how much of each one it covers.
+func areaWeights(srcSize, dstSize int) [][]contribution {
+	scale := float64(srcSize) / float64(dstSize)
+	weights := make([][]contribution, dstSize)
+	for d := 0; d < dstSize; d++ {
+		start := float64(d) * scale
+		end := start + scale
+		for s := int(start); float64(s) < end && s < srcSize; s++ {
+			covered := math.Min(end, float64(s+1)) - math.Max(start, float64(s))
+			if covered > 0 {
+				weights[d] = append(weights[d], contribution{s, covered / scale})
+			}
+		}
+	}
+	return weights
+}
+
+// resizeImage scales src down to width x height. Every target pixel is the
+// average of the source pixels it covers, first across rows, then columns.
+func resizeImage(src *image.RGBA, width, height int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	xWeights := areaWeights(sw, width)
+	yWeights := areaWeights(sh, height)
+
+	rows := make([]float32, width*sh*4)
+	for y := 0; y < sh; y++ {
+		line := src.Pix[y*src.Stride:]
+		for x, weights := range xWeights {
+			var r, g, b, a float64
+			for _, c := range weights {
+				i := c.index * 4
+				r += float64(line[i]) * c.weight
+				g += float64(line[i+1]) * c.weight
+				b += float64(line[i+2]) * c.weight
+				a += float64(line[i+3]) * c.weight
+			}
+			o := (y*width + x) * 4
+			rows[o], rows[o+1], rows[o+2], rows[o+3] = float32(r), float32(g), float32(b), float32(a)
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y, weights := range yWeights {
+		for x := 0; x < width; x++ {
+			var px [4]float64
+			for _, c := range weights {
+				o := (c.index*width + x) * 4
+				for k := 0; k < 4; k++ {
+					px[k] += float64(rows[o+k]) * c.weight
+				}
+			}
+			o := dst.PixOffset(x, y)
+			for k := 0; k < 4; k++ {
+				dst.Pix[o+k] = uint8(math.Min(255, math.Round(px[k])))
+			}
+		}
+	}
+	return dst
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG, 1 when there is
+// none. Only segments before the image data are looked at.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return 1
+		}
+		marker := data[pos+1]
+		switch {
+		case marker == 0xFF:
+			pos++
+			continue
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			pos += 2
+			continue
+		case marker == 0xDA || marker == 0xD9:
+			return 1
+		}
+
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return 1
+		}
+		segment := data[pos+4 : pos+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		pos += 2 + length
+	}
+	return 1
+}
+
+// exifOrientation reads tag 0x0112 from the first IFD of TIFF encoded EXIF.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if orientation := int(order.Uint16(tiff[entry+8:])); orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			return 1
+		}
+	}
+	return 1
+}
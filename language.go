@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/DavidBelicza/TextRank/v2"
+	"github.com/DavidBelicza/TextRank/v2/convert"
+)
+
+// Language used when neither config nor the page sets one, and the fallback
+// when there are no stopwords for the requested language.
+const defaultLanguage = "en"
+
+// Stopword lists shipped with jbmafp, one file per language code.
+//
+//go:embed files/stopwords/*.txt
+var bundledStopwords embed.FS
+
+// Stopwords maps language codes to their stopword lists.
+type Stopwords map[string][]string
+
+// languageCode reduces a language tag like "en-us" or "sl_SI" to the code
+// stopword lists are stored under.
+func languageCode(language string) string {
+	code := strings.ToLower(strings.TrimSpace(language))
+	if idx := strings.IndexAny(code, "-_"); idx >= 0 {
+		code = code[:idx]
+	}
+	if code == "" {
+		return defaultLanguage
+	}
+	return code
+}
+
+// parseStopwords reads one word per line. Empty lines and lines starting
+// with # are ignored.
+func parseStopwords(content []byte) []string {
+	var words []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		word := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		words = append(words, word)
+	}
+	return words
+}
+
+// loadStopwords reads bundled stopword lists and extends them with files in
+// the project's stopwords folder. A project file for a language that is not
+// bundled adds that language. Custom lists change what TextRank skips, so
+// they are also returned to be mixed into the build cache key.
+func loadStopwords(projectRoot string) (Stopwords, []byte, error) {
+	stopwords := Stopwords{}
+
+	bundled, err := bundledStopwords.ReadDir("files/stopwords")
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, entry := range bundled {
+		content, err := bundledStopwords.ReadFile("files/stopwords/" + entry.Name())
+		if err != nil {
+			return nil, nil, err
+		}
+		code := strings.TrimSuffix(entry.Name(), ".txt")
+		stopwords[code] = parseStopwords(content)
+	}
+
+	entries, err := os.ReadDir(path.Join(projectRoot, "stopwords"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return stopwords, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	var salt cacheSalt
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".txt" {
+			continue
+		}
+		content, err := os.ReadFile(path.Join(projectRoot, "stopwords", entry.Name()))
+		if err != nil {
+			return nil, nil, err
+		}
+		code := languageCode(strings.TrimSuffix(entry.Name(), ".txt"))
+		stopwords[code] = append(stopwords[code], parseStopwords(content)...)
+
+		salt.AddFile(entry.Name(), content)
+	}
+
+	return stopwords, salt.Bytes(), nil
+}
+
+// Has reports whether there are stopwords for a language.
+func (s Stopwords) Has(language string) bool {
+	_, ok := s[languageCode(language)]
+	return ok
+}
+
+// Language returns a TextRank language with the stopwords of the given
+// language active, English when there are none for it.
+func (s Stopwords) Language(language string) convert.Language {
+	code := languageCode(language)
+	if _, ok := s[code]; !ok {
+		code = defaultLanguage
+	}
+
+	lang := textrank.NewDefaultLanguage()
+	lang.SetWords(code, s[code])
+	lang.SetActiveLanguage(code)
+	return lang
+}
+
+// pageLanguage returns the `language` front matter field, falling back to
+// the site language from config.
+func pageLanguage(config Config, metaData map[string]interface{}) string {
+	if language, ok := metaData["language"].(string); ok && language != "" {
+		return language
+	}
+	return config.Language
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
 	"time"
@@ -20,18 +21,13 @@ import (
 	"github.com/yuin/goldmark/extension"
 	"github.com/yuin/goldmark/parser"
 	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
 
-	"github.com/DavidBelicza/TextRank/v2"
 	"github.com/alexflint/go-arg"
 	"github.com/gosimple/slug"
 	"github.com/mangoumbrella/goldmark-figure"
 	"github.com/microcosm-cc/bluemonday"
 
-	"github.com/tdewolff/minify/v2"
-	mcss "github.com/tdewolff/minify/v2/css"
-	mhtml "github.com/tdewolff/minify/v2/html"
-	mjs "github.com/tdewolff/minify/v2/js"
-
 	highlighting "github.com/yuin/goldmark-highlighting/v2"
 
 	cp "github.com/otiai10/copy"
@@ -39,10 +35,80 @@ import (
 	_ "embed"
 )
 
+// StringList accepts either a single string or a list of strings in YAML.
+type StringList []string
+
+func (l *StringList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		var item string
+		if err := value.Decode(&item); err != nil {
+			return err
+		}
+		*l = StringList{item}
+		return nil
+	}
+
+	var items []string
+	if err := value.Decode(&items); err != nil {
+		return err
+	}
+	*l = items
+	return nil
+}
+
 type ConfigExtrasItem struct {
-	Type     string `yaml:"type"`
-	Template string `yaml:"template"`
-	URL      string `yaml:"url"`
+	Type     StringList `yaml:"type"`
+	Template string     `yaml:"template"`
+	URL      string     `yaml:"url"`
+	Paginate bool       `yaml:"paginate"`
+	Drafts   bool       `yaml:"drafts"`
+}
+
+type ConfigPagination struct {
+	Size  int      `yaml:"size"`
+	Path  string   `yaml:"path"`
+	Types []string `yaml:"types"`
+}
+
+type ConfigFeed struct {
+	Format string     `yaml:"format"`
+	URL    string     `yaml:"url"`
+	Title  string     `yaml:"title"`
+	Author string     `yaml:"author"`
+	Type   StringList `yaml:"type"`
+	Limit  int        `yaml:"limit"`
+}
+
+type ConfigSearch struct {
+	Enable bool       `yaml:"enable"`
+	URL    string     `yaml:"url"`
+	Type   StringList `yaml:"type"`
+}
+
+type ConfigSummary struct {
+	Mode      string `yaml:"mode"`
+	Length    int    `yaml:"length"`
+	Sentences int    `yaml:"sentences"`
+}
+
+type ConfigSitemap struct {
+	Disable bool `yaml:"disable"`
+}
+
+type ConfigMath struct {
+	Enable bool `yaml:"enable"`
+}
+
+type ConfigAsset struct {
+	Name  string   `yaml:"name"`
+	Files []string `yaml:"files"`
+}
+
+type ConfigImages struct {
+	Widths  []int  `yaml:"widths"`
+	Quality int    `yaml:"quality"`
+	Sizes   string `yaml:"sizes"`
+	WebP    bool   `yaml:"webp"`
 }
 
 type Config struct {
@@ -51,22 +117,52 @@ type Config struct {
 	BaseURL      string             `yaml:"baseurl"`
 	Language     string             `yaml:"language"`
 	Highlighting string             `yaml:"highlighting"`
+	Timezone     string             `yaml:"timezone"`
+	Permalink    string             `yaml:"permalink"`
 	Minify       bool               `yaml:"minify"`
+	Pagination   ConfigPagination   `yaml:"pagination"`
+	Sitemap      ConfigSitemap      `yaml:"sitemap"`
+	Math         ConfigMath         `yaml:"math"`
+	Images       ConfigImages       `yaml:"images"`
+	Feeds        []ConfigFeed       `yaml:"feeds"`
+	Search       ConfigSearch       `yaml:"search"`
+	Assets       []ConfigAsset      `yaml:"assets"`
+	Summary      ConfigSummary      `yaml:"summary"`
 	Extras       []ConfigExtrasItem `yaml:"extras"`
 }
 
+// BuildOptions controls how a build is run.
+type BuildOptions struct {
+	Jobs    int
+	Clean   bool
+	Drafts  bool
+	Future  bool
+	Expired bool
+}
+
 type Page struct {
 	Filepath     string
 	Raw          string
 	HTML         template.HTML
 	Text         string
 	Summary      string
+	SummaryHTML  template.HTML
 	Meta         map[string]interface{}
 	Title        string
+	Language     string
 	Type         string
 	RelPermalink string
 	Created      time.Time
 	Draft        bool
+	Expires      time.Time
+	Status       string
+	Tags         []string
+	WordCount    int
+	ReadingTime  int
+	TOC          []*TOCEntry
+	TOCHTML      template.HTML
+	Lastmod      time.Time
+	Sitemap      bool
 }
 
 //go:embed "files/config.yaml"
@@ -84,6 +180,15 @@ var EmbedTemplateIndex string
 //go:embed "files/post.html"
 var EmbedTemplatePost string
 
+//go:embed "files/tag.html"
+var EmbedTemplateTag string
+
+//go:embed "files/tags.html"
+var EmbedTemplateTags string
+
+//go:embed "files/search.html"
+var EmbedTemplateSearch string
+
 //go:embed "files/index.xml"
 var EmbedTemplateFeed string
 
@@ -94,30 +199,18 @@ func cleanHTMLTags(htmlString string) string {
 	return cleanString
 }
 
-func includeTemplateList(projectRoot string) []string {
-	var templateFiles []string
-	includesTemplatePathname := path.Join(projectRoot, "templates/includes")
-	err := filepath.Walk(includesTemplatePathname, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if filepath.Ext(path) == ".html" {
-			templateFiles = append(templateFiles, path)
-		}
-
-		return nil
-	})
-
-	if err != nil {
-		panic(err)
+func simpleServer(projectRoot string, watch bool, options BuildOptions) {
+	publicRoot := path.Join(projectRoot, "public")
+	fs := http.FileServer(http.Dir(publicRoot))
+	if watch {
+		broker := newReloadBroker()
+		http.Handle(reloadEndpoint, broker)
+		http.Handle("/", injectReload(publicRoot, fs))
+		go watchProject(projectRoot, options, broker)
+		log.Println("Watching for changes")
+	} else {
+		http.Handle("/", fs)
 	}
-
-	return templateFiles
-}
-
-func simpleServer(projectRoot string) {
-	fs := http.FileServer(http.Dir(path.Join(projectRoot, "public")))
-	http.Handle("/", fs)
 	log.Println("Server started on http://localhost:6969")
 	log.Fatal(http.ListenAndServe(":6969", nil))
 }
@@ -154,20 +247,238 @@ func initializeProject(projectRoot string) {
 	os.WriteFile(path.Join(projectRoot, "templates", "base.html"), []byte(EmbedTemplateBase), 0755)
 	os.WriteFile(path.Join(projectRoot, "templates", "index.html"), []byte(EmbedTemplateIndex), 0755)
 	os.WriteFile(path.Join(projectRoot, "templates", "post.html"), []byte(EmbedTemplatePost), 0755)
+	os.WriteFile(path.Join(projectRoot, "templates", "tag.html"), []byte(EmbedTemplateTag), 0755)
+	os.WriteFile(path.Join(projectRoot, "templates", "tags.html"), []byte(EmbedTemplateTags), 0755)
 	os.WriteFile(path.Join(projectRoot, "templates", "index.xml"), []byte(EmbedTemplateFeed), 0755)
+	os.WriteFile(path.Join(projectRoot, "templates", "includes", "search.html"), []byte(EmbedTemplateSearch), 0755)
+}
+
+// convertMarkdown expands shortcodes and runs goldmark and TextRank on a
+// markdown source. This is the expensive part of a build and its result is
+// cached.
+func convertMarkdown(md goldmark.Markdown, shortcodes *Shortcodes, config Config, stopwords Stopwords, page Page, relFilepath string, source []byte, bodyOffset int) (cachedPage, error) {
+	// Shortcodes are expanded before goldmark sees the body, front matter
+	// is kept so line numbers in errors stay right.
+	expansion := &shortcodeExpansion{
+		shortcodes: shortcodes,
+		md:         md,
+		page:       page,
+		config:     config,
+		source:     source,
+	}
+	body, err := expansion.Expand(source[bodyOffset:], bodyOffset)
+	if err != nil {
+		return cachedPage{}, fmt.Errorf("%s: %w", relFilepath, err)
+	}
+	source = append(source[:bodyOffset:bodyOffset], body...)
+
+	// Parse and render separately so headings can be collected for TOC.
+	var buf bytes.Buffer
+	ctx := parser.NewContext()
+	doc := md.Parser().Parse(text.NewReader(source), parser.WithContext(ctx))
+	if err := md.Renderer().Render(&buf, source, doc); err != nil {
+		return cachedPage{}, fmt.Errorf("%s: %w", relFilepath, err)
+	}
+	htmlContent := expansion.Replace(buf.String())
+
+	language := stopwords.Language(page.Language)
+	summary, summaryHTML := summarize(config.Summary, language, htmlContent, page.Meta)
+
+	return cachedPage{
+		HTML:        htmlContent,
+		Text:        cleanHTMLTags(htmlContent),
+		Summary:     summary,
+		SummaryHTML: string(summaryHTML),
+		TOC:         buildTOC(doc, source),
+	}, nil
 }
 
-func buildProject(projectRoot string) {
+// parsePage converts a single markdown file into a Page, reusing the cached
+// conversion when the source did not change. All problems with the file are
+// returned instead of aborting on the first one.
+func parsePage(md goldmark.Markdown, cache *BuildCache, shortcodes *Shortcodes, config Config, stopwords Stopwords, location *time.Location, projectRoot string, file string) (Page, []error) {
+	relFilepath := relativeFilepath(projectRoot, file)
+
+	source, err := os.ReadFile(file)
+	if err != nil {
+		return Page{}, []error{err}
+	}
+
+	// Front matter is cheap to parse and never cached, see cachedPage.
+	metaData, bodyOffset, err := parseFrontMatter(source)
+	if err != nil {
+		return Page{}, []error{FrontMatterError{
+			File:    relFilepath,
+			Message: fmt.Sprintf("invalid front matter: %s", err),
+		}}
+	}
+
+	// Dates are checked even when other fields are wrong so every problem
+	// in the page is reported at once.
+	errs := validateFrontMatter(relFilepath, metaData)
+
+	var t time.Time
+	if value, ok := metaData["date"].(string); ok && value != "" {
+		t, err = parseDate(value, location)
+		if err != nil {
+			errs = append(errs, FrontMatterError{relFilepath, "date", err.Error()})
+		}
+	}
+
+	// Last modification is taken from front matter or the file itself.
+	var lastmod time.Time
+	if value, ok := metaData["lastmod"].(string); ok {
+		lastmod, err = parseDate(value, location)
+		if err != nil {
+			errs = append(errs, FrontMatterError{relFilepath, "lastmod", err.Error()})
+		}
+	} else if info, err := os.Stat(file); err == nil {
+		lastmod = info.ModTime().In(location).Truncate(time.Second)
+	}
+
+	var expires time.Time
+	if value, ok := metaData["expires"].(string); ok {
+		expires, err = parseDate(value, location)
+		if err != nil {
+			errs = append(errs, FrontMatterError{relFilepath, "expires", err.Error()})
+		}
+	}
+
+	// Explicit url wins over the permalink pattern from config.
+	url, _ := metaData["url"].(string)
+	var relPermalink string
+	if url != "" {
+		relPermalink, err = normalizeURL(url)
+		if err != nil {
+			errs = append(errs, FrontMatterError{relFilepath, "url", err.Error()})
+		}
+	} else if config.Permalink == "" {
+		errs = append(errs, FrontMatterError{relFilepath, "url", "missing required field (or set `permalink` in config.yaml)"})
+	}
+
+	if len(errs) > 0 {
+		return Page{}, errs
+	}
+
+	// The pattern needs valid fields, so it is expanded last.
+	if url == "" {
+		relPermalink, err = normalizeURL(expandPermalink(config.Permalink, metaData, t, file))
+		if err != nil {
+			return Page{}, []error{FrontMatterError{relFilepath, "url", err.Error()}}
+		}
+	}
+
+	inSitemap := true
+	if value, ok := metaData["sitemap"].(bool); ok {
+		inSitemap = value
+	}
+
+	page := Page{
+		Filepath:     file,
+		Meta:         metaData,
+		Title:        metaData["title"].(string),
+		Language:     pageLanguage(config, metaData),
+		Type:         metaData["type"].(string),
+		RelPermalink: relPermalink,
+		Created:      t,
+		Draft:        metaData["draft"].(bool),
+		Expires:      expires,
+		Tags:         tagsFromMeta(metaData),
+		Lastmod:      lastmod,
+		Sitemap:      inSitemap,
+	}
+
+	// Shortcodes can use the page path, so it is part of the key.
+	key := cache.Key([]byte(relFilepath), source)
+	entry, cached := cache.Get(key)
+	if !cached {
+		entry, err = convertMarkdown(md, shortcodes, config, stopwords, page, relFilepath, source, bodyOffset)
+		if err != nil {
+			return Page{}, []error{err}
+		}
+		if err := cache.Put(key, entry); err != nil {
+			log.Println("Could not write cache:", err)
+		}
+	}
+
+	page.Raw = entry.HTML
+	page.HTML = template.HTML(entry.HTML)
+	page.Text = entry.Text
+	page.Summary = entry.Summary
+	page.SummaryHTML = template.HTML(entry.SummaryHTML)
+	page.WordCount = countWords(entry.Text)
+	page.ReadingTime = readingTime(page.WordCount)
+	page.TOC = entry.TOC
+	page.TOCHTML = renderTOC(entry.TOC)
+	return page, nil
+}
+
+// newMarkdown sets up goldmark with every extension jbmafp uses. Render
+// hooks are added when the project has any.
+func newMarkdown(config Config, hooks *MarkupHooks) goldmark.Markdown {
+	extensions := []goldmark.Extender{
+		extension.GFM,
+		extension.Table,
+		extension.TaskList,
+		extension.Footnote,
+		meta.Meta,
+		figure.Figure,
+		highlighting.NewHighlighting(
+			highlighting.WithStyle(config.Highlighting),
+		),
+	}
+	if config.Math.Enable {
+		extensions = append(extensions, &mathExtension{})
+	}
+	if hooks != nil && hooks.Len() > 0 {
+		extensions = append(extensions, hooks)
+	}
+
+	return goldmark.New(
+		goldmark.WithExtensions(extensions...),
+		goldmark.WithParserOptions(
+			parser.WithAutoHeadingID(),
+			parser.WithBlockParsers(),
+			parser.WithInlineParsers(),
+			parser.WithParagraphTransformers(),
+			parser.WithAttribute(),
+		),
+		goldmark.WithRendererOptions(
+			html.WithXHTML(),
+			html.WithUnsafe(),
+		),
+	)
+}
+
+func buildProject(projectRoot string, options BuildOptions) error {
+	// Start from scratch when asked to.
+	if options.Clean {
+		log.Println("Cleaning public folder and build cache...")
+		if err := os.RemoveAll(path.Join(projectRoot, "public")); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(path.Join(projectRoot, ".jbmafp")); err != nil {
+			return err
+		}
+	}
+
 	// Read config file.
 	configFilepath := path.Join(projectRoot, "config.yaml")
 	configFile, err := os.ReadFile(configFilepath)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("reading config: %w", err)
 	}
 	config := Config{}
 	err = yaml.Unmarshal(configFile, &config)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("config.yaml: %w", err)
+	}
+	if config.Search.URL == "" {
+		config.Search.URL = "search.json"
+	}
+	location, err := loadTimezone(config.Timezone)
+	if err != nil {
+		return fmt.Errorf("config.yaml: field \"timezone\": %w", err)
 	}
 
 	// Gets the list of all markdown files.
@@ -185,80 +496,120 @@ func buildProject(projectRoot string) {
 	})
 
 	if err != nil {
-		fmt.Printf("No markdown files found with error `%s`.\n", err)
-		os.Exit(1)
+		return fmt.Errorf("listing markdown files: %w", err)
 	}
 
-	md := goldmark.New(
-		goldmark.WithExtensions(
-			extension.GFM,
-			extension.Table,
-			extension.TaskList,
-			extension.Footnote,
-			meta.Meta,
-			figure.Figure,
-			highlighting.NewHighlighting(
-				highlighting.WithStyle(config.Highlighting),
-			),
-		),
-		goldmark.WithParserOptions(
-			parser.WithAutoHeadingID(),
-			parser.WithBlockParsers(),
-			parser.WithInlineParsers(),
-			parser.WithParagraphTransformers(),
-			parser.WithAttribute(),
-		),
-		goldmark.WithRendererOptions(
-			html.WithXHTML(),
-			html.WithUnsafe(),
-		),
-	)
+	// Stopwords used by TextRank and the search index.
+	stopwords, customStopwords, err := loadStopwords(projectRoot)
+	if err != nil {
+		return fmt.Errorf("loading stopwords: %w", err)
+	}
+
+	// CSS and JS bundles from assets, templates link them with `asset`.
+	assets, err := buildAssets(projectRoot, config.Assets)
+	if err != nil {
+		return fmt.Errorf("config.yaml: field \"assets\": %w", err)
+	}
+
+	filters := template.FuncMap{
+		"first":        firstN,
+		"last":         lastN,
+		"random":       randomN,
+		"filterbytype": filterByType,
+		"slugify":      slug.Make,
+		"asset":        assets.Lookup,
+	}
+
+	// Shortcode templates run while converting markdown.
+	shortcodes, shortcodeSources, err := loadShortcodes(projectRoot, filters)
+	if err != nil {
+		return fmt.Errorf("parsing shortcodes: %w", err)
+	}
+
+	// Render hooks fall back to how goldmark renders without them.
+	hooks, hookSources, err := loadMarkupHooks(projectRoot, config, filters, newMarkdown(config, nil).Renderer())
+	if err != nil {
+		return fmt.Errorf("parsing render hooks: %w", err)
+	}
+	md := newMarkdown(config, hooks)
+
+	// Converted markdown is cached between builds.
+	cache, err := openBuildCache(projectRoot, configFile, customStopwords, shortcodeSources, hookSources, assets.Salt())
+	if err != nil {
+		return fmt.Errorf("opening build cache: %w", err)
+	}
 
 	// Parse all markdown files in content folder.
+	parsed := make([]Page, len(files))
+	parseErrors := make([][]error, len(files))
+	parallel(options.Jobs, len(files), func(i int) {
+		parsed[i], parseErrors[i] = parsePage(md, cache, shortcodes, config, stopwords, location, projectRoot, files[i])
+	})
+
 	pages := []Page{}
-	for _, file := range files {
-		source, err := os.ReadFile(file)
+	var validationErrors []error
+	for i := range files {
+		if len(parseErrors[i]) > 0 {
+			validationErrors = append(validationErrors, parseErrors[i]...)
+			continue
+		}
+		pages = append(pages, parsed[i])
+	}
+
+	if len(validationErrors) > 0 {
+		for _, err := range validationErrors {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return fmt.Errorf("content validation failed with %d error(s)", len(validationErrors))
+	}
+
+	if err := cache.Prune(); err != nil {
+		log.Println("Could not prune cache:", err)
+	}
+
+	// Languages without stopwords fall back to English, say so once each.
+	missingLanguages := map[string]bool{}
+	for _, page := range pages {
+		code := languageCode(page.Language)
+		if !stopwords.Has(code) && !missingLanguages[code] {
+			missingLanguages[code] = true
+			log.Printf("No stopwords for language %q, using %q. Add stopwords/%s.txt to the project.\n", code, defaultLanguage, code)
+		}
+	}
+
+	// Drafts, scheduled and expired pages are left out unless previewing.
+	pages = publishedPages(projectRoot, pages, options, time.Now())
+
+	// Images from static used by pages get resized variants and srcset.
+	var images *ImageProcessor
+	if len(config.Images.Widths) > 0 {
+		log.Println("Processing images...")
+		images, err = newImageProcessor(projectRoot, config)
 		if err != nil {
-			panic(err)
-		}
-
-		var buf bytes.Buffer
-		ctx := parser.NewContext()
-		if err := md.Convert(source, &buf, parser.WithContext(ctx)); err != nil {
-			panic(err)
-		}
-
-		// Rank and summarize.
-		tr := textrank.NewTextRank()
-		rule := textrank.NewDefaultRule()
-		language := textrank.NewDefaultLanguage()
-		algorithmDef := textrank.NewDefaultAlgorithm()
-		tr.Populate(cleanHTMLTags(buf.String()), language, rule)
-		tr.Ranking(algorithmDef)
-
-		sentences := textrank.FindSentencesByRelationWeight(tr, 50)
-		sentences = textrank.FindSentencesFrom(tr, 0, 1)
-
-		summary := ""
-		for _, s := range sentences {
-			summary = strings.ReplaceAll(s.Value, "\n", "")
-		}
-
-		metaData := meta.Get(ctx)
-		t, _ := time.Parse("2006-01-02T15:04:05-07:00", metaData["date"].(string))
-		pages = append(pages, Page{
-			Filepath:     file,
-			Meta:         metaData,
-			Raw:          buf.String(),
-			HTML:         template.HTML(buf.String()),
-			Text:         cleanHTMLTags(buf.String()),
-			Summary:      summary,
-			Title:        metaData["title"].(string),
-			Type:         metaData["type"].(string),
-			RelPermalink: metaData["url"].(string),
-			Created:      t,
-			Draft:        metaData["draft"].(bool),
+			return fmt.Errorf("opening image cache: %w", err)
+		}
+
+		imageErrors := make([]error, len(pages))
+		parallel(options.Jobs, len(pages), func(i int) {
+			if err := images.RewritePage(&pages[i]); err != nil {
+				imageErrors[i] = fmt.Errorf("%s: %w", relativeFilepath(projectRoot, pages[i].Filepath), err)
+			}
 		})
+
+		failed := 0
+		for _, err := range imageErrors {
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("processing images failed for %d page(s)", failed)
+		}
+
+		if err := images.Prune(); err != nil {
+			log.Println("Could not prune image cache:", err)
+		}
 	}
 
 	// Sorting pages in descending created order.
@@ -268,155 +619,311 @@ func buildProject(projectRoot string) {
 
 	// Creates public folder if it doesn't exist yet.
 	if err := os.Mkdir(path.Join(projectRoot, "public"), 0755); err != nil && !os.IsExist(err) {
-		log.Println("Error creating directory:", err)
-		return
+		return fmt.Errorf("creating public directory: %w", err)
 	}
 
-	filters := template.FuncMap{
-		"first":  firstN,
-		"last":   lastN,
-		"random": randomN,
-		"filterbytype": filterByType,
+	tags := collectTags(pages)
+
+	// Parse every template used by this build once.
+	registry, err := newTemplateRegistry(projectRoot, filters)
+	if err != nil {
+		return fmt.Errorf("parsing templates: %w", err)
 	}
 
-	// Generate HTML files for all pages.
+	templateNames := []string{"index.html"}
+	for _, name := range []string{"tag.html", "tags.html"} {
+		if registry.Exists(name) {
+			templateNames = append(templateNames, name)
+		}
+	}
+	for _, extra := range config.Extras {
+		templateNames = append(templateNames, extra.Template)
+	}
 	for _, page := range pages {
-		outFilepath := path.Join(projectRoot, "public", page.Meta["url"].(string))
-		if !page.Draft {
-			pageTemplateFilename := fmt.Sprintf("%s.html", page.Meta["type"].(string))
-			templatePathname := path.Join(projectRoot, "templates", pageTemplateFilename)
-			baseTemplatePathname := path.Join(projectRoot, "templates/base.html")
+		templateNames = append(templateNames, fmt.Sprintf("%s.html", page.Type))
+	}
 
-			templates := includeTemplateList(projectRoot)
-			templates = append([]string{templatePathname}, templates...)
-			templates = append([]string{baseTemplatePathname}, templates...)
+	// Templates are named after their file, so an extra called like base.html
+	// or an include would silently take its place.
+	var templateErrors []error
+	for _, extra := range config.Extras {
+		if shared, ok := registry.Shadows(extra.Template); ok {
+			templateErrors = append(templateErrors, fmt.Errorf("config.yaml: extras template %q has the same name as templates/%s, rename it", extra.Template, shared))
+		}
+	}
 
-			t, err := template.New("base.html").Funcs(filters).ParseFiles(templates...)
-			if err != nil {
-				panic(err)
-			}
+	seenTemplates := map[string]bool{}
+	for _, name := range templateNames {
+		if seenTemplates[name] {
+			continue
+		}
+		seenTemplates[name] = true
+		if err := registry.Load(name); err != nil {
+			templateErrors = append(templateErrors, err)
+		}
+	}
 
-			type Payload struct {
-				Config Config
-				Page   Page
-				Pages  []Page
-			}
+	if len(templateErrors) > 0 {
+		for _, err := range templateErrors {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return fmt.Errorf("parsing templates failed with %d error(s)", len(templateErrors))
+	}
 
-			var buf bytes.Buffer
-			err = t.Execute(&buf, Payload{
-				Config: config,
-				Page:   page,
-				Pages:  pages,
-			})
-			if err != nil {
-				panic(err)
-			}
+	// Render HTML for all pages. Nothing is written until everything rendered
+	// fine so a failing build leaves public untouched.
+	renderErrors := make([]error, len(pages))
+	pageOutputs := make([]*Output, len(pages))
+	parallel(options.Jobs, len(pages), func(i int) {
+		page := pages[i]
+		outHTML, err := renderHTML(registry, fmt.Sprintf("%s.html", page.Type), Payload{
+			Config: config,
+			Page:   page,
+			Pages:  pages,
+			Tags:   tags,
+		})
+		if err != nil {
+			renderErrors[i] = fmt.Errorf("%s: %w", relativeFilepath(projectRoot, page.Filepath), err)
+			return
+		}
 
-			outHTML := buf.String()
-			if config.Minify {
-				m := minify.New()
-				m.AddFunc("text/html", mhtml.Minify)
-				m.AddFunc("text/css", mcss.Minify)
-				m.AddFunc("application/js", mjs.Minify)
-				outHTML, err = m.String("text/html", outHTML)
-				if err != nil {
-					panic(err)
-				}
-			}
+		pageOutputs[i] = &Output{URL: outputPath(page.RelPermalink), Content: outHTML, Source: relativeFilepath(projectRoot, page.Filepath)}
+	})
 
-			os.WriteFile(outFilepath, []byte(outHTML), 0755)
-			log.Println("Wrote", outFilepath)
-		} else {
-			log.Println("Skipped", outFilepath)
+	failed := 0
+	for _, err := range renderErrors {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("rendering failed for %d page(s)", failed)
+	}
+
+	outputs := []Output{}
+	for _, output := range pageOutputs {
+		if output != nil {
+			outputs = append(outputs, *output)
 		}
 	}
 
 	// Generates index page.
 	{
+		log.Println("Rendering index...")
+		listing := pagesOfTypes(pages, config.Pagination.Types)
+		for _, paginator := range paginate(listing, config.Pagination.Size, "index.html", config.Pagination.Path) {
+			outHTML, err := renderHTML(registry, "index.html", Payload{
+				Config:    config,
+				Pages:     pages,
+				Tags:      tags,
+				Paginator: paginator,
+			})
+			if err != nil {
+				return fmt.Errorf("rendering index: %w", err)
+			}
 
-		log.Println("Writing index...")
-		templatePathname := path.Join(projectRoot, "templates/index.html")
-		baseTemplatePathname := path.Join(projectRoot, "templates/base.html")
-
-		templates := includeTemplateList(projectRoot)
-		templates = append([]string{templatePathname}, templates...)
-		templates = append([]string{baseTemplatePathname}, templates...)
-
-		t, err := template.New("base.html").Funcs(filters).ParseFiles(templates...)
-		if err != nil {
-			panic(err)
+			outputs = append(outputs, Output{URL: paginator.RelPermalink, Content: outHTML, Source: "templates/index.html"})
 		}
+	}
+
+	// Generates tag listing pages and tags index when templates exist.
+	if registry.Exists("tag.html") {
+		log.Println("Rendering tag pages...")
+		for _, tag := range sortedTags(tags) {
+			for _, paginator := range paginate(tag.Pages, config.Pagination.Size, tag.RelPermalink, config.Pagination.Path) {
+				outHTML, err := renderHTML(registry, "tag.html", Payload{
+					Config:    config,
+					Pages:     pages,
+					Tags:      tags,
+					Tag:       tag,
+					Paginator: paginator,
+				})
+				if err != nil {
+					return fmt.Errorf("rendering tag %s: %w", tag.Name, err)
+				}
 
-		type Payload struct {
-			Config Config
-			Pages  []Page
+				outputs = append(outputs, Output{URL: paginator.RelPermalink, Content: outHTML, Source: fmt.Sprintf("templates/tag.html (tag %q)", tag.Name)})
+			}
 		}
+	}
 
-		var buf bytes.Buffer
-		err = t.Execute(&buf, Payload{
+	if registry.Exists("tags.html") {
+		log.Println("Rendering tags index...")
+		outHTML, err := renderHTML(registry, "tags.html", Payload{
 			Config: config,
 			Pages:  pages,
+			Tags:   tags,
 		})
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("rendering tags index: %w", err)
 		}
 
-		outHTML := buf.String()
-		if config.Minify {
-			m := minify.New()
-			m.AddFunc("text/html", mhtml.Minify)
-			m.AddFunc("text/css", mcss.Minify)
-			m.AddFunc("application/js", mjs.Minify)
-			outHTML, err = m.String("text/html", outHTML)
+		outputs = append(outputs, Output{URL: "tags/index.html", Content: outHTML, Source: "templates/tags.html"})
+	}
+
+	// Generates sitemap unless disabled or provided as an extra.
+	if !config.Sitemap.Disable {
+		customSitemap := false
+		for _, extra := range config.Extras {
+			if path.Clean(extra.URL) == "sitemap.xml" {
+				customSitemap = true
+			}
+		}
+
+		if customSitemap {
+			log.Println("Skipping built-in sitemap, extras provide sitemap.xml")
+		} else {
+			log.Println("Rendering sitemap...")
+			sitemapOutputs, err := buildSitemap(config, pages)
 			if err != nil {
-				panic(err)
+				return fmt.Errorf("rendering sitemap: %w", err)
 			}
+			outputs = append(outputs, sitemapOutputs...)
 		}
+	}
 
-		outFilepath := path.Join(projectRoot, "public", "index.html")
-		os.WriteFile(outFilepath, []byte(outHTML), 0755)
+	// Generates Atom and JSON feeds.
+	for _, feed := range config.Feeds {
+		log.Printf("Rendering %s feed %s\n", feed.Format, feed.URL)
+		content, err := buildFeed(config, feed, pages)
+		if err != nil {
+			return fmt.Errorf("rendering feed %s: %w", feed.URL, err)
+		}
+		outputs = append(outputs, Output{URL: feed.URL, Content: content, Source: fmt.Sprintf("%s feed in config.yaml", feed.Format)})
 	}
 
-	// Copy static files.
-	{
-		log.Println("Copying static files...")
-		err := cp.Copy(path.Join(projectRoot, "static"), path.Join(projectRoot, "public"))
+	// Generates search index.
+	if config.Search.Enable {
+		log.Println("Rendering search index...")
+		content, err := buildSearchIndex(config, pages, stopwords)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("rendering search index: %w", err)
 		}
+		outputs = append(outputs, Output{URL: config.Search.URL, Content: content, Source: "search index in config.yaml"})
 	}
 
-	// Generates extras.
+	// Generates extras. Extras go through the same templates as pages but
+	// start at their own template instead of base.html.
+	extraOutputs := []Output{}
 	{
 		for _, extra := range config.Extras {
-			log.Printf("Writing extras %s\n", extra.URL)
-			templatePathname := path.Join(projectRoot, "templates", extra.Template)
-			t, err := template.ParseFiles(templatePathname)
-			if err != nil {
-				panic(err)
+			log.Printf("Rendering extras %s\n", extra.URL)
+
+			// Only pages of configured types, drafts only when asked for.
+			extraPages := []Page{}
+			for _, page := range pages {
+				if page.Draft && !extra.Drafts {
+					continue
+				}
+				if len(extra.Type) > 0 && !containsString(extra.Type, page.Type) {
+					continue
+				}
+				extraPages = append(extraPages, page)
 			}
 
-			type Payload struct {
-				Config Config
-				Pages  []Page
+			size := 0
+			if extra.Paginate {
+				size = config.Pagination.Size
 			}
 
-			var buf bytes.Buffer
-			err = t.Execute(&buf, Payload{
-				Config: config,
-				Pages:  pages,
-			})
-			if err != nil {
-				panic(err)
+			for _, paginator := range paginate(extraPages, size, extra.URL, config.Pagination.Path) {
+				content, err := renderTemplate(registry, extra.Template, path.Base(extra.Template), paginator.RelPermalink, Payload{
+					Config:    config,
+					Pages:     extraPages,
+					Tags:      tags,
+					Paginator: paginator,
+				})
+				if err != nil {
+					return fmt.Errorf("rendering extras %s: %w", extra.URL, err)
+				}
+
+				extraOutputs = append(extraOutputs, Output{URL: paginator.RelPermalink, Content: content, Source: fmt.Sprintf("extras templates/%s", extra.Template)})
 			}
+		}
+	}
+
+	// Asset bundles are written together with pages.
+	outputs = append(outputs, assets.Outputs()...)
 
-			outFilepath := path.Join(projectRoot, "public", extra.URL)
-			os.WriteFile(outFilepath, []byte(buf.String()), 0755)
+	staticFiles, err := staticFileList(projectRoot)
+	if err != nil {
+		return fmt.Errorf("listing static files: %w", err)
+	}
+
+	// Processed images are published as variants instead of copied.
+	var imageOutputs []Output
+	if images != nil {
+		imageOutputs, err = images.Outputs()
+		if err != nil {
+			return fmt.Errorf("reading image cache: %w", err)
+		}
+		copied := staticFiles[:0]
+		for _, file := range staticFiles {
+			if !images.Processed(file) {
+				copied = append(copied, file)
+			}
+		}
+		staticFiles = copied
+	}
+
+	// Refuse to write anything when two sources would write the same file.
+	planned := append(append(append([]Output{}, outputs...), extraOutputs...), imageOutputs...)
+	for _, file := range staticFiles {
+		planned = append(planned, Output{URL: file, Source: path.Join("static", file)})
+	}
+	if collisions := findCollisions(planned); len(collisions) > 0 {
+		for _, err := range collisions {
+			fmt.Fprintln(os.Stderr, err)
 		}
+		return fmt.Errorf("found %d output collision(s)", len(collisions))
+	}
+
+	// Everything rendered, write pages and index.
+	publicRoot := path.Join(projectRoot, "public")
+	if err := writeOutputs(publicRoot, outputs); err != nil {
+		return err
+	}
+
+	// Copy static files.
+	{
+		log.Println("Copying static files...")
+		staticRoot := path.Join(projectRoot, "static")
+		err := cp.Copy(staticRoot, publicRoot, cp.Options{
+			Skip: func(srcinfo os.FileInfo, src, dest string) (bool, error) {
+				if rel, err := filepath.Rel(staticRoot, src); err == nil && images != nil && images.Processed(rel) {
+					return true, nil
+				}
+				return skipUnchangedStatic(srcinfo, src, dest)
+			},
+			PreserveTimes: true,
+		})
+		if err != nil {
+			return fmt.Errorf("copying static files: %w", err)
+		}
+	}
+
+	// Write image variants and extras.
+	if err := writeOutputs(publicRoot, imageOutputs); err != nil {
+		return err
+	}
+	if err := writeOutputs(publicRoot, extraOutputs); err != nil {
+		return err
+	}
+
+	// Remove files produced by the previous build that are gone now.
+	produced := append([]string{}, staticFiles...)
+	for _, output := range append(append(outputs, extraOutputs...), imageOutputs...) {
+		produced = append(produced, path.Clean(output.URL))
+	}
+	removeOrphans(publicRoot, loadManifest(projectRoot), produced)
+	if err := saveManifest(projectRoot, produced); err != nil {
+		log.Println("Could not save manifest:", err)
 	}
 
 	// Guess we are done!
 	log.Println("Done & done...")
+	return nil
 }
 
 func newPage(projectRoot string, title string) {
@@ -459,11 +966,17 @@ func main() {
 	}
 
 	var args struct {
-		Init   bool   `arg:"-i,--init" help:"initialize new project"`
-		Build  bool   `arg:"-b,--build" help:"build the website"`
-		Server bool   `arg:"-s,--server" help:"simple embedded HTTP server"`
-		New    bool   `arg:"-n,--new" help:"create new page"`
-		Title  string `arg:"positional"`
+		Init    bool   `arg:"-i,--init" help:"initialize new project"`
+		Build   bool   `arg:"-b,--build" help:"build the website"`
+		Server  bool   `arg:"-s,--server" help:"simple embedded HTTP server"`
+		Watch   bool   `arg:"-w,--watch" help:"rebuild on changes and reload browser (with --server)"`
+		Jobs    int    `arg:"-j,--jobs" help:"number of pages rendered in parallel (default: number of CPUs)"`
+		Drafts  bool   `arg:"-d,--drafts" help:"include drafts"`
+		Future  bool   `arg:"-f,--future" help:"include pages with a date in the future"`
+		Expired bool   `arg:"-e,--expired" help:"include expired pages"`
+		Clean   bool   `arg:"-c,--clean" help:"remove public folder and build cache before building"`
+		New     bool   `arg:"-n,--new" help:"create new page"`
+		Title   string `arg:"positional"`
 	}
 
 	arg.MustParse(&args)
@@ -473,16 +986,41 @@ func main() {
 		os.Exit(0)
 	}
 
+	if args.Watch && !args.Server {
+		fmt.Println("Watch mode requires `--server`")
+		os.Exit(1)
+	}
+
 	if args.Init {
 		initializeProject(projectRoot)
 	}
 
-	if args.Build {
-		buildProject(projectRoot)
+	options := BuildOptions{
+		Jobs:    args.Jobs,
+		Clean:   args.Clean,
+		Drafts:  args.Drafts,
+		Future:  args.Future,
+		Expired: args.Expired,
+	}
+	if options.Jobs <= 0 {
+		options.Jobs = runtime.NumCPU()
+	}
+
+	if args.Watch {
+		// Errors in the first build get fixed by editing files, so the
+		// server keeps running and rebuilds on change.
+		safeBuild(projectRoot, options)
+		options.Clean = false
+	} else if args.Build {
+		if err := buildProject(projectRoot, options); err != nil {
+			log.Println("Build failed:", err)
+			os.Exit(1)
+		}
+		options.Clean = false
 	}
 
 	if args.Server {
-		simpleServer(projectRoot)
+		simpleServer(projectRoot, args.Watch, options)
 	}
 
 	if args.New {
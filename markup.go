@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// Render hooks that can be overridden with templates/_markup/<name>.html.
+var markupHookNames = []string{"render-link", "render-image", "render-heading", "render-codeblock"}
+
+// MarkupLink is the payload of render-link.html. Autolinks use it as well,
+// their Text is the link itself. External is set for links to other hosts
+// than the one in baseurl.
+type MarkupLink struct {
+	Destination string
+	Title       string
+	Text        template.HTML
+	PlainText   string
+	External    bool
+}
+
+// MarkupImage is the payload of render-image.html. Text is the alt text.
+type MarkupImage struct {
+	Destination string
+	Title       string
+	Text        string
+}
+
+// MarkupHeading is the payload of render-heading.html. Anchor is the id
+// goldmark generated, the same one the table of contents links to.
+type MarkupHeading struct {
+	Level     int
+	Anchor    string
+	Text      template.HTML
+	PlainText string
+}
+
+// MarkupCodeBlock is the payload of render-codeblock.html. HTML is the
+// highlighted block jbmafp renders without the hook.
+type MarkupCodeBlock struct {
+	Language string
+	Code     string
+	HTML     template.HTML
+}
+
+// MarkupHooks renders nodes with templates from templates/_markup. Nodes
+// without a template render as usual.
+type MarkupHooks struct {
+	templates map[string]*template.Template
+	host      string
+
+	// fallback renders without hooks, renderer is the one hooks belong to
+	// and is used for content inside hooked nodes.
+	fallback renderer.Renderer
+	renderer renderer.Renderer
+}
+
+// loadMarkupHooks parses render hook templates with the same filters pages
+// get. Fallback renders nodes the way they would be without hooks. The
+// second result salts the build cache with the hook templates.
+func loadMarkupHooks(projectRoot string, config Config, filters template.FuncMap, fallback renderer.Renderer) (*MarkupHooks, []byte, error) {
+	hooks := &MarkupHooks{
+		templates: map[string]*template.Template{},
+		fallback:  fallback,
+	}
+	if base, err := url.Parse(config.BaseURL); err == nil {
+		hooks.host = strings.ToLower(base.Hostname())
+	}
+
+	var salt cacheSalt
+	for _, name := range markupHookNames {
+		content, err := os.ReadFile(path.Join(projectRoot, "templates", "_markup", name+".html"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, nil, err
+		}
+
+		t, err := template.New(name + ".html").Funcs(filters).Parse(string(content))
+		if err != nil {
+			return nil, nil, err
+		}
+		hooks.templates[name] = t
+
+		salt.AddFile(name, content)
+	}
+
+	return hooks, salt.Bytes(), nil
+}
+
+// Len returns the number of hooks with a template.
+func (h *MarkupHooks) Len() int {
+	return len(h.templates)
+}
+
+// Extend registers the hooks after every other renderer so they win.
+func (h *MarkupHooks) Extend(m goldmark.Markdown) {
+	h.renderer = m.Renderer()
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(h, 100),
+	))
+}
+
+func (h *MarkupHooks) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	if _, ok := h.templates["render-link"]; ok {
+		reg.Register(ast.KindLink, h.renderLink)
+		reg.Register(ast.KindAutoLink, h.renderAutoLink)
+	}
+	if _, ok := h.templates["render-image"]; ok {
+		reg.Register(ast.KindImage, h.renderImage)
+	}
+	if _, ok := h.templates["render-heading"]; ok {
+		reg.Register(ast.KindHeading, h.renderHeading)
+	}
+	if _, ok := h.templates["render-codeblock"]; ok {
+		reg.Register(ast.KindFencedCodeBlock, h.renderCodeBlock)
+	}
+}
+
+// execute runs a hook template and writes its output. Block hooks end with
+// a newline like the rest of goldmark output.
+func (h *MarkupHooks) execute(w util.BufWriter, name string, data interface{}, block bool) (ast.WalkStatus, error) {
+	var buf bytes.Buffer
+	if err := h.templates[name].Execute(&buf, data); err != nil {
+		return ast.WalkStop, err
+	}
+	w.WriteString(strings.TrimSpace(buf.String()))
+	if block {
+		w.WriteByte('\n')
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+// isExternal reports whether a link points to another host than baseurl.
+// Relative links and fragments never do.
+func (h *MarkupHooks) isExternal(destination string) bool {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	return host != "" && host != h.host
+}
+
+// renderChildren renders what is inside a node, hooks included.
+func (h *MarkupHooks) renderChildren(source []byte, node ast.Node) (template.HTML, error) {
+	var buf bytes.Buffer
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		if err := h.renderer.Render(&buf, source, child); err != nil {
+			return "", err
+		}
+	}
+	return template.HTML(buf.String()), nil
+}
+
+func (h *MarkupHooks) renderLink(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.Link)
+	text, err := h.renderChildren(source, n)
+	if err != nil {
+		return ast.WalkStop, err
+	}
+	return h.execute(w, "render-link", MarkupLink{
+		Destination: string(n.Destination),
+		Title:       string(n.Title),
+		Text:        text,
+		PlainText:   string(n.Text(source)),
+		External:    h.isExternal(string(n.Destination)),
+	}, false)
+}
+
+func (h *MarkupHooks) renderAutoLink(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.AutoLink)
+	destination := string(n.URL(source))
+	if n.AutoLinkType == ast.AutoLinkEmail && !strings.HasPrefix(strings.ToLower(destination), "mailto:") {
+		destination = "mailto:" + destination
+	}
+	label := string(n.Label(source))
+	return h.execute(w, "render-link", MarkupLink{
+		Destination: destination,
+		Text:        template.HTML(template.HTMLEscapeString(label)),
+		PlainText:   label,
+		External:    h.isExternal(destination),
+	}, false)
+}
+
+func (h *MarkupHooks) renderImage(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.Image)
+	return h.execute(w, "render-image", MarkupImage{
+		Destination: string(n.Destination),
+		Title:       string(n.Title),
+		Text:        string(n.Text(source)),
+	}, false)
+}
+
+func (h *MarkupHooks) renderHeading(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.Heading)
+	text, err := h.renderChildren(source, n)
+	if err != nil {
+		return ast.WalkStop, err
+	}
+
+	payload := MarkupHeading{
+		Level:     n.Level,
+		Text:      text,
+		PlainText: string(n.Text(source)),
+	}
+	if id, ok := n.AttributeString("id"); ok {
+		if b, ok := id.([]byte); ok {
+			payload.Anchor = string(b)
+		}
+	}
+	return h.execute(w, "render-heading", payload, true)
+}
+
+func (h *MarkupHooks) renderCodeBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.FencedCodeBlock)
+
+	var code strings.Builder
+	for i := 0; i < n.Lines().Len(); i++ {
+		segment := n.Lines().At(i)
+		code.Write(segment.Value(source))
+	}
+
+	var highlighted bytes.Buffer
+	if err := h.fallback.Render(&highlighted, source, n); err != nil {
+		return ast.WalkStop, err
+	}
+
+	return h.execute(w, "render-codeblock", MarkupCodeBlock{
+		Language: string(n.Language(source)),
+		Code:     code.String(),
+		HTML:     template.HTML(highlighted.String()),
+	}, true)
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+var mathDelimiter = []byte("$$")
+
+var KindMath = ast.NewNodeKind("Math")
+var KindMathBlock = ast.NewNodeKind("MathBlock")
+
+// Math is inline math written as $...$, or $$...$$ inside a paragraph.
+type Math struct {
+	ast.BaseInline
+	TeX     string
+	Display bool
+	Offset  int
+}
+
+func (n *Math) Kind() ast.NodeKind {
+	return KindMath
+}
+
+func (n *Math) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"TeX": n.TeX}, nil)
+}
+
+// MathBlock is display math with $$ on its own lines.
+type MathBlock struct {
+	ast.BaseBlock
+	Closed bool
+	Offset int
+}
+
+func (n *MathBlock) Kind() ast.NodeKind {
+	return KindMathBlock
+}
+
+func (n *MathBlock) IsRaw() bool {
+	return true
+}
+
+func (n *MathBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// MathError is a math expression that could not be converted.
+type MathError struct {
+	Line    int
+	TeX     string
+	Message string
+}
+
+func (e MathError) Error() string {
+	tex := strings.Join(strings.Fields(e.TeX), " ")
+	if runes := []rune(tex); len(runes) > 40 {
+		tex = string(runes[:40]) + "…"
+	}
+	return fmt.Sprintf("line %d: %s in math \"%s\"", e.Line, e.Message, tex)
+}
+
+type mathInlineParser struct{}
+
+func (p *mathInlineParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+// Parse follows the usual rules to keep prices like $5 and $10 as text: the
+// opening $ must not be followed by a space, the closing one must not be
+// preceded by a space nor followed by a digit. Math never spans a backtick
+// so code spans keep their dollars.
+func (p *mathInlineParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	delim := 1
+	if len(line) > 1 && line[1] == '$' {
+		delim = 2
+	}
+	if delim == 1 && (len(line) < 2 || util.IsSpace(line[1])) {
+		return nil
+	}
+
+	l, pos := block.Position()
+	block.Advance(delim)
+
+	var tex []byte
+	offset := -1
+	for {
+		line, segment := block.PeekLine()
+		if line == nil {
+			block.SetPosition(l, pos)
+			return nil
+		}
+		if offset < 0 {
+			offset = segment.Start
+		}
+
+		for i := 0; i < len(line); i++ {
+			switch line[i] {
+			case '\\':
+				i++
+			case '`':
+				block.SetPosition(l, pos)
+				return nil
+			case '$':
+				if delim == 2 {
+					if i+1 >= len(line) || line[i+1] != '$' {
+						continue
+					}
+				} else if i == 0 || util.IsSpace(line[i-1]) || (i+1 < len(line) && line[i+1] >= '0' && line[i+1] <= '9') {
+					block.SetPosition(l, pos)
+					return nil
+				}
+
+				tex = append(tex, line[:i]...)
+				if len(bytes.TrimSpace(tex)) == 0 {
+					block.SetPosition(l, pos)
+					return nil
+				}
+				block.Advance(i + delim)
+				return &Math{TeX: string(tex), Display: delim == 2, Offset: offset}
+			}
+		}
+
+		tex = append(tex, line...)
+		block.AdvanceLine()
+	}
+}
+
+type mathBlockParser struct{}
+
+func (b *mathBlockParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (b *mathBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || !bytes.HasPrefix(line[pos:], mathDelimiter) {
+		return nil, parser.NoChildren
+	}
+
+	start := segment.Start + pos + len(mathDelimiter)
+	rest := line[pos+len(mathDelimiter):]
+	node := &MathBlock{Offset: start}
+
+	if idx := bytes.Index(rest, mathDelimiter); idx >= 0 {
+		// $$...$$ on a single line, text after it makes it inline math.
+		if !util.IsBlank(rest[idx+len(mathDelimiter):]) {
+			return nil, parser.NoChildren
+		}
+		node.Lines().Append(text.NewSegment(start, start+idx))
+		node.Closed = true
+	} else if !util.IsBlank(rest) {
+		node.Lines().Append(text.NewSegment(start, segment.Stop))
+	}
+
+	reader.Advance(segment.Len() - 1)
+	return node, parser.NoChildren
+}
+
+func (b *mathBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	n := node.(*MathBlock)
+	if n.Closed {
+		return parser.Close
+	}
+
+	line, segment := reader.PeekLine()
+	if trimmed := util.TrimRightSpace(line); bytes.HasSuffix(trimmed, mathDelimiter) {
+		idx := len(trimmed) - len(mathDelimiter)
+		if !util.IsBlank(line[:idx]) {
+			node.Lines().Append(text.NewSegment(segment.Start, segment.Start+idx))
+		}
+		n.Closed = true
+		reader.Advance(segment.Len() - 1)
+		return parser.Close
+	}
+
+	node.Lines().Append(segment)
+	reader.Advance(segment.Len() - 1)
+	return parser.Continue | parser.NoChildren
+}
+
+func (b *mathBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {
+}
+
+func (b *mathBlockParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (b *mathBlockParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+// mathRenderer writes math nodes as MathML.
+type mathRenderer struct{}
+
+func (r *mathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindMath, r.renderMath)
+	reg.Register(KindMathBlock, r.renderMathBlock)
+}
+
+func (r *mathRenderer) renderMath(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*Math)
+	if err := writeMath(w, source, n.TeX, n.Display, n.Offset); err != nil {
+		return ast.WalkStop, err
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *mathRenderer) renderMathBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*MathBlock)
+
+	var tex bytes.Buffer
+	offset := n.Offset
+	for i := 0; i < n.Lines().Len(); i++ {
+		segment := n.Lines().At(i)
+		if i == 0 {
+			offset = segment.Start
+		}
+		tex.Write(segment.Value(source))
+	}
+
+	if !n.Closed {
+		return ast.WalkStop, MathError{lineAt(source, n.Offset), tex.String(), "missing closing $$"}
+	}
+	if err := writeMath(w, source, tex.String(), true, offset); err != nil {
+		return ast.WalkStop, err
+	}
+	w.WriteByte('\n')
+	return ast.WalkSkipChildren, nil
+}
+
+// writeMath converts TeX found at offset in source and writes MathML.
+func writeMath(w util.BufWriter, source []byte, tex string, display bool, offset int) error {
+	mathml, err := texToMathML(tex, display)
+	if err != nil {
+		line := lineAt(source, offset)
+		if texErr, ok := err.(*texError); ok {
+			line += strings.Count(string([]rune(tex)[:texErr.Pos]), "\n")
+		}
+		return MathError{line, tex, err.Error()}
+	}
+	w.WriteString(mathml)
+	return nil
+}
+
+// lineAt returns the 1-based line number of offset in source.
+func lineAt(source []byte, offset int) int {
+	if offset > len(source) {
+		offset = len(source)
+	}
+	return bytes.Count(source[:offset], []byte("\n")) + 1
+}
+
+// mathExtension adds $...$ and $$...$$ math rendered as MathML.
+type mathExtension struct{}
+
+func (e *mathExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(util.Prioritized(&mathBlockParser{}, 690)),
+		parser.WithInlineParsers(util.Prioritized(&mathInlineParser{}, 150)),
+	)
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&mathRenderer{}, 500),
+	))
+}
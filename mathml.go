@@ -0,0 +1,1092 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+)
+
+// texError is a problem found while converting TeX. Pos is the offset in
+// runes into the TeX source so callers can point at the right line.
+type texError struct {
+	Pos     int
+	Message string
+}
+
+func (e *texError) Error() string {
+	return e.Message
+}
+
+// mathItem is a converted piece of math. Limits marks operators whose
+// scripts go below and above in display style, Apply marks functions like
+// \sin that are followed by an invisible function application.
+type mathItem struct {
+	Markup string
+	Limits bool
+	Apply  bool
+}
+
+// texParser converts a subset of LaTeX math into MathML.
+type texParser struct {
+	src     []rune
+	pos     int
+	display bool
+	font    string
+}
+
+// Lowercase and uppercase greek letters. Uppercase ones are upright in TeX.
+var texGreek = map[string]string{
+	`\alpha`: "α", `\beta`: "β", `\gamma`: "γ", `\delta`: "δ",
+	`\epsilon`: "ϵ", `\varepsilon`: "ε", `\zeta`: "ζ", `\eta`: "η",
+	`\theta`: "θ", `\vartheta`: "ϑ", `\iota`: "ι", `\kappa`: "κ",
+	`\varkappa`: "ϰ", `\lambda`: "λ", `\mu`: "μ", `\nu`: "ν",
+	`\xi`: "ξ", `\omicron`: "ο", `\pi`: "π", `\varpi`: "ϖ",
+	`\rho`: "ρ", `\varrho`: "ϱ", `\sigma`: "σ", `\varsigma`: "ς",
+	`\tau`: "τ", `\upsilon`: "υ", `\phi`: "ϕ", `\varphi`: "φ",
+	`\chi`: "χ", `\psi`: "ψ", `\omega`: "ω",
+}
+
+var texUpperGreek = map[string]string{
+	`\Gamma`: "Γ", `\Delta`: "Δ", `\Theta`: "Θ", `\Lambda`: "Λ",
+	`\Xi`: "Ξ", `\Pi`: "Π", `\Sigma`: "Σ", `\Upsilon`: "Υ",
+	`\Phi`: "Φ", `\Psi`: "Ψ", `\Omega`: "Ω",
+}
+
+// Symbols that behave like identifiers.
+var texIdentifiers = map[string]string{
+	`\infty`: "∞", `\ell`: "ℓ", `\hbar`: "ℏ", `\imath`: "ı", `\jmath`: "ȷ",
+	`\Re`: "ℜ", `\Im`: "ℑ", `\aleph`: "ℵ", `\beth`: "ℶ", `\wp`: "℘",
+	`\emptyset`: "∅", `\varnothing`: "∅", `\nabla`: "∇", `\partial`: "∂",
+	`\top`: "⊤", `\bot`: "⊥", `\angle`: "∠", `\triangle`: "△",
+	`\backslash`: "\\", `\complement`: "∁", `\prime`: "′",
+	`\%`: "%", `\$`: "$", `\#`: "#", `\&`: "&", `\_`: "_",
+}
+
+// Binary operators, relations, arrows and punctuation.
+var texOperators = map[string]string{
+	`\pm`: "±", `\mp`: "∓", `\times`: "×", `\div`: "÷", `\cdot`: "⋅",
+	`\ast`: "∗", `\star`: "⋆", `\circ`: "∘", `\bullet`: "∙",
+	`\oplus`: "⊕", `\ominus`: "⊖", `\otimes`: "⊗", `\oslash`: "⊘",
+	`\odot`: "⊙", `\setminus`: "∖", `\wedge`: "∧", `\land`: "∧",
+	`\vee`: "∨", `\lor`: "∨", `\cap`: "∩", `\cup`: "∪", `\sqcap`: "⊓",
+	`\sqcup`: "⊔", `\uplus`: "⊎", `\dagger`: "†", `\ddagger`: "‡",
+	`\amalg`: "⨿", `\wr`: "≀",
+
+	`\leq`: "≤", `\le`: "≤", `\geq`: "≥", `\ge`: "≥", `\neq`: "≠",
+	`\ne`: "≠", `\lt`: "<", `\gt`: ">", `\ll`: "≪", `\gg`: "≫",
+	`\leqslant`: "⩽", `\geqslant`: "⩾", `\approx`: "≈", `\equiv`: "≡",
+	`\sim`: "∼", `\simeq`: "≃", `\cong`: "≅", `\propto`: "∝",
+	`\asymp`: "≍", `\doteq`: "≐", `\triangleq`: "≜", `\coloneqq`: "≔",
+	`\prec`: "≺", `\succ`: "≻", `\preceq`: "⪯", `\succeq`: "⪰",
+	`\in`: "∈", `\notin`: "∉", `\ni`: "∋", `\subset`: "⊂",
+	`\subseteq`: "⊆", `\supset`: "⊃", `\supseteq`: "⊇",
+	`\sqsubseteq`: "⊑", `\sqsupseteq`: "⊒", `\mid`: "∣", `\nmid`: "∤",
+	`\parallel`: "∥", `\nparallel`: "∦", `\perp`: "⊥", `\models`: "⊨",
+	`\vdash`: "⊢", `\dashv`: "⊣",
+
+	`\to`: "→", `\rightarrow`: "→", `\gets`: "←", `\leftarrow`: "←",
+	`\leftrightarrow`: "↔", `\Rightarrow`: "⇒", `\Leftarrow`: "⇐",
+	`\Leftrightarrow`: "⇔", `\implies`: "⟹", `\impliedby`: "⟸",
+	`\iff`: "⟺", `\longrightarrow`: "⟶", `\longleftarrow`: "⟵",
+	`\Longrightarrow`: "⟹", `\Longleftarrow`: "⟸",
+	`\longleftrightarrow`: "⟷", `\mapsto`: "↦", `\longmapsto`: "⟼",
+	`\uparrow`: "↑", `\downarrow`: "↓", `\Uparrow`: "⇑",
+	`\Downarrow`: "⇓", `\updownarrow`: "↕", `\nearrow`: "↗",
+	`\searrow`: "↘", `\hookrightarrow`: "↪", `\hookleftarrow`: "↩",
+	`\rightharpoonup`: "⇀", `\leftharpoonup`: "↼",
+	`\rightleftharpoons`: "⇌",
+
+	`\neg`: "¬", `\lnot`: "¬", `\forall`: "∀", `\exists`: "∃",
+	`\nexists`: "∄", `\therefore`: "∴", `\because`: "∵",
+	`\ldots`: "…", `\dots`: "…", `\cdots`: "⋯", `\vdots`: "⋮",
+	`\ddots`: "⋱", `\colon`: ":",
+}
+
+// Delimiters usable on their own and after \left, \right and \big.
+var texDelimiters = map[string]string{
+	"(": "(", ")": ")", "[": "[", "]": "]", "|": "|", "/": "/",
+	`\{`: "{", `\}`: "}", `\lbrace`: "{", `\rbrace`: "}",
+	`\langle`: "⟨", `\rangle`: "⟩", `\lfloor`: "⌊", `\rfloor`: "⌋",
+	`\lceil`: "⌈", `\rceil`: "⌉", `\vert`: "|", `\Vert`: "‖",
+	`\lvert`: "|", `\rvert`: "|", `\lVert`: "‖", `\rVert`: "‖",
+	`\|`: "‖", ".": "",
+}
+
+// Large operators. Scripts go below and above in display style unless
+// Limits is false.
+var texBigOperators = map[string]mathItem{
+	`\sum`: {Markup: "∑", Limits: true}, `\prod`: {Markup: "∏", Limits: true},
+	`\coprod`: {Markup: "∐", Limits: true}, `\bigcup`: {Markup: "⋃", Limits: true},
+	`\bigcap`: {Markup: "⋂", Limits: true}, `\bigvee`: {Markup: "⋁", Limits: true},
+	`\bigwedge`: {Markup: "⋀", Limits: true}, `\bigoplus`: {Markup: "⨁", Limits: true},
+	`\bigotimes`: {Markup: "⨂", Limits: true}, `\bigodot`: {Markup: "⨀", Limits: true},
+	`\bigsqcup`: {Markup: "⨆", Limits: true}, `\biguplus`: {Markup: "⨄", Limits: true},
+	`\int`: {Markup: "∫"}, `\iint`: {Markup: "∬"}, `\iiint`: {Markup: "∭"},
+	`\oint`: {Markup: "∮"},
+}
+
+// Named functions. The ones with Limits take their scripts below in display
+// style, the others are followed by a function application.
+var texFunctions = map[string]mathItem{
+	`\lim`: {Markup: "lim", Limits: true}, `\liminf`: {Markup: "lim inf", Limits: true},
+	`\limsup`: {Markup: "lim sup", Limits: true}, `\max`: {Markup: "max", Limits: true},
+	`\min`: {Markup: "min", Limits: true}, `\sup`: {Markup: "sup", Limits: true},
+	`\inf`: {Markup: "inf", Limits: true}, `\det`: {Markup: "det", Limits: true},
+	`\gcd`: {Markup: "gcd", Limits: true}, `\Pr`: {Markup: "Pr", Limits: true},
+	`\sin`: {Markup: "sin"}, `\cos`: {Markup: "cos"}, `\tan`: {Markup: "tan"},
+	`\cot`: {Markup: "cot"}, `\sec`: {Markup: "sec"}, `\csc`: {Markup: "csc"},
+	`\arcsin`: {Markup: "arcsin"}, `\arccos`: {Markup: "arccos"},
+	`\arctan`: {Markup: "arctan"}, `\sinh`: {Markup: "sinh"},
+	`\cosh`: {Markup: "cosh"}, `\tanh`: {Markup: "tanh"},
+	`\coth`: {Markup: "coth"}, `\log`: {Markup: "log"}, `\lg`: {Markup: "lg"},
+	`\ln`: {Markup: "ln"}, `\exp`: {Markup: "exp"}, `\arg`: {Markup: "arg"},
+	`\deg`: {Markup: "deg"}, `\dim`: {Markup: "dim"}, `\hom`: {Markup: "hom"},
+	`\ker`: {Markup: "ker"},
+}
+
+var texSpaces = map[string]string{
+	`\,`: "0.1667em", `\:`: "0.2222em", `\>`: "0.2222em", `\;`: "0.2778em",
+	`\!`: "-0.1667em", `\ `: "0.25em", `\quad`: "1em", `\qquad`: "2em",
+	"~": "0.25em",
+}
+
+// Accents placed over their argument. Wide ones stretch to its width.
+var texAccents = map[string]struct {
+	Mark    string
+	Stretch bool
+}{
+	`\hat`: {"^", false}, `\widehat`: {"^", true}, `\check`: {"ˇ", false},
+	`\tilde`: {"~", false}, `\widetilde`: {"~", true}, `\acute`: {"´", false},
+	`\grave`: {"`", false}, `\dot`: {"˙", false}, `\ddot`: {"¨", false},
+	`\breve`: {"˘", false}, `\bar`: {"¯", false}, `\vec`: {"→", false},
+	`\mathring`: {"˚", false}, `\overline`: {"‾", true},
+	`\overrightarrow`: {"→", true}, `\overleftarrow`: {"←", true},
+}
+
+var texUnderAccents = map[string]string{
+	`\underline`: "_",
+}
+
+var texBraces = map[string]struct {
+	Mark  string
+	Under bool
+}{
+	`\overbrace`:  {"⏞", false},
+	`\underbrace`: {"⏟", true},
+}
+
+var texFonts = map[string]string{
+	`\mathrm`: "rm", `\mathit`: "it", `\mathbf`: "bf", `\boldsymbol`: "bi",
+	`\mathsf`: "sf", `\mathtt`: "tt", `\mathbb`: "bb", `\mathcal`: "cal",
+	`\mathscr`: "cal", `\mathfrak`: "frak",
+}
+
+var texTextCommands = map[string]string{
+	`\text`: "", `\textrm`: "", `\mbox`: "", `\textup`: "",
+	`\textbf`: "bold", `\textit`: "italic", `\textsf`: "sans-serif",
+	`\texttt`: "monospace",
+}
+
+// Sizes of \big and friends.
+var texBigSizes = map[string]string{
+	`\big`: "1.2em", `\bigl`: "1.2em", `\bigr`: "1.2em", `\bigm`: "1.2em",
+	`\Big`: "1.623em", `\Bigl`: "1.623em", `\Bigr`: "1.623em", `\Bigm`: "1.623em",
+	`\bigg`: "2.047em", `\biggl`: "2.047em", `\biggr`: "2.047em", `\biggm`: "2.047em",
+	`\Bigg`: "2.470em", `\Biggl`: "2.470em", `\Biggr`: "2.470em", `\Biggm`: "2.470em",
+}
+
+var texStyles = map[string]string{
+	`\displaystyle`:      `displaystyle="true" scriptlevel="0"`,
+	`\textstyle`:         `displaystyle="false" scriptlevel="0"`,
+	`\scriptstyle`:       `displaystyle="false" scriptlevel="1"`,
+	`\scriptscriptstyle`: `displaystyle="false" scriptlevel="2"`,
+}
+
+// Negated relations written with \not.
+var texNegations = map[string]string{
+	"=": "≠", "<": "≮", ">": "≯", `\in`: "∉", `\equiv`: "≢",
+	`\subset`: "⊄", `\supset`: "⊅", `\subseteq`: "⊈", `\supseteq`: "⊉",
+	`\le`: "≰", `\leq`: "≰", `\ge`: "≱", `\geq`: "≱", `\sim`: "≁",
+	`\approx`: "≉", `\cong`: "≇", `\mid`: "∤", `\parallel`: "∦",
+}
+
+// texEnvironment describes how a \begin{...} environment is laid out.
+type texEnvironment struct {
+	Open    string
+	Close   string
+	Align   string
+	Display bool
+	Script  bool
+}
+
+var texEnvironments = map[string]texEnvironment{
+	"matrix":      {},
+	"pmatrix":     {Open: "(", Close: ")"},
+	"bmatrix":     {Open: "[", Close: "]"},
+	"Bmatrix":     {Open: "{", Close: "}"},
+	"vmatrix":     {Open: "|", Close: "|"},
+	"Vmatrix":     {Open: "‖", Close: "‖"},
+	"smallmatrix": {Script: true},
+	"cases":       {Open: "{", Align: "left"},
+	"array":       {},
+	"aligned":     {Align: "aligned", Display: true},
+	"align":       {Align: "aligned", Display: true},
+	"align*":      {Align: "aligned", Display: true},
+	"split":       {Align: "aligned", Display: true},
+	"gathered":    {Display: true},
+	"gather":      {Display: true},
+	"gather*":     {Display: true},
+	"equation":    {Display: true},
+	"equation*":   {Display: true},
+}
+
+// Invisible operator placed between a function name and its argument.
+const functionApplication = "<mo>\u2061</mo>"
+
+// Characters that are operators when they appear on their own.
+const texOperatorChars = "+-=<>()[]|/,;:!?.*@"
+
+// Characters that are stretchy in MathML by default but not in TeX.
+const texFenceChars = "()[]{}|‖⟨⟩⌊⌋⌈⌉"
+
+// Unicode math alphabets used by font commands: start of capitals, small
+// letters and digits, plus letters that live elsewhere in Unicode.
+var texAlphabets = map[string]struct {
+	Upper, Lower, Digit rune
+	Holes               map[rune]rune
+}{
+	"bf": {Upper: 0x1D400, Lower: 0x1D41A, Digit: 0x1D7CE},
+	"bi": {Upper: 0x1D468, Lower: 0x1D482, Digit: 0x1D7CE},
+	"sf": {Upper: 0x1D5A0, Lower: 0x1D5BA, Digit: 0x1D7E2},
+	"tt": {Upper: 0x1D670, Lower: 0x1D68A, Digit: 0x1D7F6},
+	"bb": {Upper: 0x1D538, Lower: 0x1D552, Digit: 0x1D7D8, Holes: map[rune]rune{
+		'C': 'ℂ', 'H': 'ℍ', 'N': 'ℕ', 'P': 'ℙ', 'Q': 'ℚ', 'R': 'ℝ', 'Z': 'ℤ',
+	}},
+	"cal": {Upper: 0x1D49C, Lower: 0x1D4B6, Holes: map[rune]rune{
+		'B': 'ℬ', 'E': 'ℰ', 'F': 'ℱ', 'H': 'ℋ', 'I': 'ℐ', 'L': 'ℒ', 'M': 'ℳ',
+		'R': 'ℛ', 'e': 'ℯ', 'g': 'ℊ', 'o': 'ℴ',
+	}},
+	"frak": {Upper: 0x1D504, Lower: 0x1D51E, Holes: map[rune]rune{
+		'C': 'ℭ', 'H': 'ℌ', 'I': 'ℑ', 'R': 'ℜ', 'Z': 'ℨ',
+	}},
+}
+
+// texToMathML converts TeX math into a <math> element.
+func texToMathML(tex string, display bool) (string, error) {
+	p := &texParser{src: []rune(tex), display: display}
+	items, err := p.parseList()
+	if err != nil {
+		return "", err
+	}
+	if tok := p.peek(); tok != "" {
+		return "", p.unexpected(tok)
+	}
+
+	attrs := ""
+	if display {
+		attrs = ` display="block"`
+	}
+	return `<math xmlns="http://www.w3.org/1998/Math/MathML"` + attrs + `>` + mrow(items) + `</math>`, nil
+}
+
+func (p *texParser) errorf(format string, args ...interface{}) error {
+	return &texError{Pos: p.pos, Message: fmt.Sprintf(format, args...)}
+}
+
+// unexpected reports a token that ends a list where it is not allowed.
+func (p *texParser) unexpected(tok string) error {
+	switch tok {
+	case "}":
+		return p.errorf("unexpected }")
+	case "&":
+		return p.errorf("& is only allowed inside matrices and aligned environments")
+	case `\\`:
+		return p.errorf(`\\ is only allowed inside matrices and aligned environments`)
+	case `\end`:
+		return p.errorf(`\end without matching \begin`)
+	case `\right`:
+		return p.errorf(`\right without matching \left`)
+	case `\middle`:
+		return p.errorf(`\middle without matching \left`)
+	case "":
+		return p.errorf("unexpected end of math")
+	}
+	return p.errorf("unexpected %s", tok)
+}
+
+func (p *texParser) skipSpace() {
+	for p.pos < len(p.src) {
+		r := p.src[p.pos]
+		if r == '%' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		}
+		if !unicode.IsSpace(r) {
+			return
+		}
+		p.pos++
+	}
+}
+
+// peek returns the next token without consuming it: a command like \frac
+// or \{, or a single character. Empty at the end of the source.
+func (p *texParser) peek() string {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return ""
+	}
+	if p.src[p.pos] != '\\' {
+		return string(p.src[p.pos])
+	}
+	end := p.pos + 1
+	for end < len(p.src) && isASCIILetter(p.src[end]) {
+		end++
+	}
+	if end == p.pos+1 && end < len(p.src) {
+		end++
+	}
+	return string(p.src[p.pos:end])
+}
+
+func (p *texParser) next() string {
+	tok := p.peek()
+	p.pos += len([]rune(tok))
+	return tok
+}
+
+func isASCIILetter(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+// isListEnd reports tokens that end a list of atoms.
+func isListEnd(tok string) bool {
+	switch tok {
+	case "", "}", "&", `\\`, `\end`, `\right`, `\middle`:
+		return true
+	}
+	return false
+}
+
+func joinItems(items []mathItem) string {
+	var b strings.Builder
+	for _, item := range items {
+		b.WriteString(item.Markup)
+	}
+	return b.String()
+}
+
+// mrow groups items, single items are left as they are.
+func mrow(items []mathItem) string {
+	if len(items) == 1 {
+		return items[0].Markup
+	}
+	return "<mrow>" + joinItems(items) + "</mrow>"
+}
+
+func mo(op string) string {
+	if op != "" && strings.ContainsRune(texFenceChars, []rune(op)[0]) {
+		return `<mo stretchy="false">` + html.EscapeString(op) + `</mo>`
+	}
+	return "<mo>" + html.EscapeString(op) + "</mo>"
+}
+
+func mspace(width string) string {
+	return `<mspace width="` + width + `"></mspace>`
+}
+
+// parseList parses atoms until the end of the current group, cell or
+// source. The token that ended the list is not consumed.
+func (p *texParser) parseList() ([]mathItem, error) {
+	var items []mathItem
+	for {
+		tok := p.peek()
+		if isListEnd(tok) {
+			return items, nil
+		}
+
+		// Style switches apply to the rest of the list.
+		if style, ok := texStyles[tok]; ok {
+			p.next()
+			saved := p.display
+			p.display = tok == `\displaystyle`
+			rest, err := p.parseList()
+			p.display = saved
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, mathItem{Markup: "<mstyle " + style + ">" + joinItems(rest) + "</mstyle>"})
+			return items, nil
+		}
+
+		item, err := p.parseScripted()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+// parseGroup parses the inside of {...}, the opening brace is already
+// consumed.
+func (p *texParser) parseGroup() (string, error) {
+	items, err := p.parseList()
+	if err != nil {
+		return "", err
+	}
+	if tok := p.next(); tok != "}" {
+		if tok == "" {
+			return "", p.errorf("missing }")
+		}
+		return "", p.unexpected(tok)
+	}
+	if len(items) == 0 {
+		return "<mrow></mrow>", nil
+	}
+	return mrow(items), nil
+}
+
+// parseArgument parses a command argument or script: a group or a single
+// token.
+func (p *texParser) parseArgument(command string) (string, error) {
+	tok := p.peek()
+	if isListEnd(tok) || tok == "^" || tok == "_" {
+		return "", p.errorf("%s is missing an argument", command)
+	}
+	if tok == "{" {
+		p.next()
+		return p.parseGroup()
+	}
+	item, err := p.parseAtom(true)
+	if err != nil {
+		return "", err
+	}
+	if item.Apply {
+		item.Markup += functionApplication
+	}
+	return item.Markup, nil
+}
+
+// readBraced reads a {...} argument as raw text.
+func (p *texParser) readBraced(command string) (string, error) {
+	if p.peek() != "{" {
+		return "", p.errorf("%s expects an argument in braces", command)
+	}
+	p.pos++
+	start := p.pos
+	depth := 1
+	for ; p.pos < len(p.src); p.pos++ {
+		switch p.src[p.pos] {
+		case '\\':
+			p.pos++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				text := string(p.src[start:p.pos])
+				p.pos++
+				return text, nil
+			}
+		}
+	}
+	return "", p.errorf("missing } after %s", command)
+}
+
+// readOptional reads an optional [...] argument as raw text.
+func (p *texParser) readOptional() (string, bool) {
+	if p.peek() != "[" {
+		return "", false
+	}
+	p.pos++
+	start := p.pos
+	for ; p.pos < len(p.src); p.pos++ {
+		if p.src[p.pos] == ']' {
+			text := string(p.src[start:p.pos])
+			p.pos++
+			return text, true
+		}
+	}
+	p.pos = start - 1
+	return "", false
+}
+
+// parseScripted parses an atom with its sub- and superscripts and primes.
+func (p *texParser) parseScripted() (mathItem, error) {
+	base, err := p.parseAtom(false)
+	if err != nil {
+		return mathItem{}, err
+	}
+
+	switch p.peek() {
+	case `\limits`:
+		p.next()
+		base.Limits = true
+	case `\nolimits`:
+		p.next()
+		base.Limits = false
+	}
+
+	var sub, sup, primes string
+	hasSub, hasSup := false, false
+	for {
+		tok := p.peek()
+		if tok == "'" {
+			p.next()
+			primes += "′"
+			continue
+		}
+		if tok != "^" && tok != "_" {
+			break
+		}
+		p.next()
+		if (tok == "^" && hasSup) || (tok == "_" && hasSub) {
+			return mathItem{}, p.errorf("double %s, use braces to group scripts", map[string]string{"^": "superscript", "_": "subscript"}[tok])
+		}
+		arg, err := p.parseArgument(tok)
+		if err != nil {
+			return mathItem{}, err
+		}
+		if tok == "^" {
+			sup, hasSup = arg, true
+		} else {
+			sub, hasSub = arg, true
+		}
+	}
+	if primes != "" {
+		if hasSup {
+			sup = "<mrow>" + mo(primes) + sup + "</mrow>"
+		} else {
+			sup = mo(primes)
+		}
+		hasSup = true
+	}
+
+	markup := base.Markup
+	if hasSub || hasSup {
+		under := base.Limits && p.display
+		switch {
+		case hasSub && hasSup && under:
+			markup = "<munderover>" + markup + sub + sup + "</munderover>"
+		case hasSub && hasSup:
+			markup = "<msubsup>" + markup + sub + sup + "</msubsup>"
+		case hasSub && under:
+			markup = "<munder>" + markup + sub + "</munder>"
+		case hasSub:
+			markup = "<msub>" + markup + sub + "</msub>"
+		case under:
+			markup = "<mover>" + markup + sup + "</mover>"
+		default:
+			markup = "<msup>" + markup + sup + "</msup>"
+		}
+	}
+	if base.Apply {
+		markup += functionApplication
+	}
+	return mathItem{Markup: markup}, nil
+}
+
+// parseAtom parses a single character, group or command. With single set
+// numbers are read one digit at a time, like TeX does for \frac12.
+func (p *texParser) parseAtom(single bool) (mathItem, error) {
+	tok := p.peek()
+	switch {
+	case tok == "^" || tok == "_":
+		// Scripts without a base attach to an empty one.
+		return mathItem{Markup: "<mrow></mrow>"}, nil
+	case isListEnd(tok):
+		return mathItem{}, p.unexpected(tok)
+	case tok == "{":
+		p.next()
+		markup, err := p.parseGroup()
+		return mathItem{Markup: markup}, err
+	case len(tok) > 1 && tok[0] == '\\':
+		p.next()
+		return p.parseCommand(tok)
+	}
+
+	p.next()
+	r := []rune(tok)[0]
+	switch {
+	case r >= '0' && r <= '9':
+		number := tok
+		for !single && p.pos < len(p.src) {
+			c := p.src[p.pos]
+			if c >= '0' && c <= '9' {
+				number += string(c)
+				p.pos++
+			} else if c == '.' && p.pos+1 < len(p.src) && p.src[p.pos+1] >= '0' && p.src[p.pos+1] <= '9' {
+				number += string(c)
+				p.pos++
+			} else {
+				break
+			}
+		}
+		return mathItem{Markup: "<mn>" + p.applyFont(number) + "</mn>"}, nil
+	case unicode.IsLetter(r):
+		if p.font == "rm" {
+			return mathItem{Markup: `<mi mathvariant="normal">` + html.EscapeString(tok) + "</mi>"}, nil
+		}
+		return mathItem{Markup: "<mi>" + p.applyFont(tok) + "</mi>"}, nil
+	case r == '\'':
+		return mathItem{Markup: mo("′")}, nil
+	case r == '~':
+		return mathItem{Markup: mspace(texSpaces["~"])}, nil
+	case r == '-':
+		return mathItem{Markup: mo("−")}, nil
+	case r == '*':
+		return mathItem{Markup: mo("∗")}, nil
+	case strings.ContainsRune(texOperatorChars, r):
+		return mathItem{Markup: mo(tok)}, nil
+	case r == '#' || r == '$' || r == '\\':
+		return mathItem{}, p.errorf("unexpected %s", tok)
+	case unicode.IsNumber(r):
+		return mathItem{Markup: "<mn>" + html.EscapeString(tok) + "</mn>"}, nil
+	}
+	return mathItem{Markup: mo(tok)}, nil
+}
+
+// applyFont maps letters and digits to the Unicode alphabet of the active
+// font command and escapes the result.
+func (p *texParser) applyFont(s string) string {
+	alphabet, ok := texAlphabets[p.font]
+	if !ok {
+		return html.EscapeString(s)
+	}
+
+	var b strings.Builder
+	for _, r := range s {
+		if mapped, ok := alphabet.Holes[r]; ok {
+			b.WriteRune(mapped)
+			continue
+		}
+		switch {
+		case r >= 'A' && r <= 'Z' && alphabet.Upper != 0:
+			b.WriteRune(alphabet.Upper + r - 'A')
+		case r >= 'a' && r <= 'z' && alphabet.Lower != 0:
+			b.WriteRune(alphabet.Lower + r - 'a')
+		case r >= '0' && r <= '9' && alphabet.Digit != 0:
+			b.WriteRune(alphabet.Digit + r - '0')
+		default:
+			b.WriteString(html.EscapeString(string(r)))
+		}
+	}
+	return b.String()
+}
+
+// parseCommand converts a command whose name is already consumed.
+func (p *texParser) parseCommand(tok string) (mathItem, error) {
+	if symbol, ok := texGreek[tok]; ok {
+		return mathItem{Markup: "<mi>" + symbol + "</mi>"}, nil
+	}
+	if symbol, ok := texUpperGreek[tok]; ok {
+		return mathItem{Markup: `<mi mathvariant="normal">` + symbol + "</mi>"}, nil
+	}
+	if symbol, ok := texIdentifiers[tok]; ok {
+		return mathItem{Markup: "<mi>" + html.EscapeString(symbol) + "</mi>"}, nil
+	}
+	if symbol, ok := texOperators[tok]; ok {
+		return mathItem{Markup: mo(symbol)}, nil
+	}
+	if symbol, ok := texDelimiters[tok]; ok && symbol != "" {
+		return mathItem{Markup: mo(symbol)}, nil
+	}
+	if op, ok := texBigOperators[tok]; ok {
+		return mathItem{Markup: "<mo>" + op.Markup + "</mo>", Limits: op.Limits}, nil
+	}
+	if fn, ok := texFunctions[tok]; ok {
+		return mathItem{Markup: "<mi>" + fn.Markup + "</mi>", Limits: fn.Limits, Apply: !fn.Limits}, nil
+	}
+	if width, ok := texSpaces[tok]; ok {
+		return mathItem{Markup: mspace(width)}, nil
+	}
+
+	if accent, ok := texAccents[tok]; ok {
+		arg, err := p.parseArgument(tok)
+		if err != nil {
+			return mathItem{}, err
+		}
+		return mathItem{Markup: `<mover accent="true">` + arg + `<mo stretchy="` + fmt.Sprint(accent.Stretch) + `">` + html.EscapeString(accent.Mark) + "</mo></mover>"}, nil
+	}
+	if mark, ok := texUnderAccents[tok]; ok {
+		arg, err := p.parseArgument(tok)
+		if err != nil {
+			return mathItem{}, err
+		}
+		return mathItem{Markup: `<munder accentunder="true">` + arg + `<mo stretchy="true">` + mark + "</mo></munder>"}, nil
+	}
+	if brace, ok := texBraces[tok]; ok {
+		arg, err := p.parseArgument(tok)
+		if err != nil {
+			return mathItem{}, err
+		}
+		if brace.Under {
+			return mathItem{Markup: "<munder>" + arg + `<mo stretchy="true">` + brace.Mark + "</mo></munder>", Limits: true}, nil
+		}
+		return mathItem{Markup: "<mover>" + arg + `<mo stretchy="true">` + brace.Mark + "</mo></mover>", Limits: true}, nil
+	}
+
+	if font, ok := texFonts[tok]; ok {
+		saved := p.font
+		p.font = font
+		arg, err := p.parseArgument(tok)
+		p.font = saved
+		return mathItem{Markup: arg}, err
+	}
+	if variant, ok := texTextCommands[tok]; ok {
+		return p.parseText(tok, variant)
+	}
+	if size, ok := texBigSizes[tok]; ok {
+		symbol, err := p.parseDelimiter(tok)
+		if err != nil {
+			return mathItem{}, err
+		}
+		return mathItem{Markup: `<mo stretchy="true" minsize="` + size + `" maxsize="` + size + `">` + html.EscapeString(symbol) + "</mo>"}, nil
+	}
+
+	switch tok {
+	case `\frac`, `\dfrac`, `\tfrac`, `\cfrac`:
+		return p.parseFraction(tok, "")
+	case `\binom`, `\dbinom`, `\tbinom`:
+		return p.parseFraction(tok, ` linethickness="0"`)
+	case `\sqrt`:
+		return p.parseSqrt()
+	case `\left`:
+		return p.parseLeftRight()
+	case `\begin`:
+		name, err := p.readBraced(tok)
+		if err != nil {
+			return mathItem{}, err
+		}
+		return p.parseEnvironment(name)
+	case `\overset`, `\stackrel`, `\underset`:
+		over, err := p.parseArgument(tok)
+		if err != nil {
+			return mathItem{}, err
+		}
+		base, err := p.parseArgument(tok)
+		if err != nil {
+			return mathItem{}, err
+		}
+		if tok == `\underset` {
+			return mathItem{Markup: "<munder>" + base + over + "</munder>"}, nil
+		}
+		return mathItem{Markup: "<mover>" + base + over + "</mover>"}, nil
+	case `\operatorname`:
+		limits := false
+		if p.peek() == "*" {
+			p.next()
+			limits = true
+		}
+		name, err := p.readBraced(tok)
+		if err != nil {
+			return mathItem{}, err
+		}
+		return mathItem{Markup: "<mi>" + html.EscapeString(strings.TrimSpace(name)) + "</mi>", Limits: limits, Apply: !limits}, nil
+	case `\not`:
+		next := p.next()
+		if symbol, ok := texNegations[next]; ok {
+			return mathItem{Markup: mo(symbol)}, nil
+		}
+		return mathItem{}, p.errorf(`\not%s is not supported`, next)
+	case `\bmod`:
+		return mathItem{Markup: `<mo lspace="0.2222em" rspace="0.2222em">mod</mo>`}, nil
+	case `\mod`, `\pmod`:
+		arg, err := p.parseArgument(tok)
+		if err != nil {
+			return mathItem{}, err
+		}
+		if tok == `\pmod` {
+			return mathItem{Markup: "<mrow>" + mspace("1em") + mo("(") + "<mi>mod</mi>" + mspace("0.3333em") + arg + mo(")") + "</mrow>"}, nil
+		}
+		return mathItem{Markup: "<mrow>" + mspace("1em") + "<mi>mod</mi>" + mspace("0.3333em") + arg + "</mrow>"}, nil
+	}
+
+	return mathItem{}, p.errorf("unsupported command %s", tok)
+}
+
+// parseFraction handles \frac and \binom along with their display and
+// text style variants.
+func (p *texParser) parseFraction(tok string, attrs string) (mathItem, error) {
+	saved := p.display
+	p.display = false
+	num, err := p.parseArgument(tok)
+	if err != nil {
+		return mathItem{}, err
+	}
+	den, err := p.parseArgument(tok)
+	if err != nil {
+		return mathItem{}, err
+	}
+	p.display = saved
+
+	markup := "<mfrac" + attrs + ">" + num + den + "</mfrac>"
+	if strings.HasSuffix(tok, "binom") {
+		markup = "<mrow><mo>(</mo>" + markup + "<mo>)</mo></mrow>"
+	}
+	switch tok[1] {
+	case 'd', 'c':
+		markup = `<mstyle displaystyle="true" scriptlevel="0">` + markup + "</mstyle>"
+	case 't':
+		markup = `<mstyle displaystyle="false">` + markup + "</mstyle>"
+	}
+	return mathItem{Markup: markup}, nil
+}
+
+// parseSqrt handles \sqrt{x} and \sqrt[n]{x}.
+func (p *texParser) parseSqrt() (mathItem, error) {
+	index, hasIndex := p.readOptional()
+	arg, err := p.parseArgument(`\sqrt`)
+	if err != nil {
+		return mathItem{}, err
+	}
+	if !hasIndex {
+		return mathItem{Markup: "<msqrt>" + arg + "</msqrt>"}, nil
+	}
+
+	sub := &texParser{src: []rune(index), font: p.font}
+	items, err := sub.parseList()
+	if err == nil && sub.peek() != "" {
+		err = sub.unexpected(sub.peek())
+	}
+	if err != nil {
+		return mathItem{}, p.errorf(`in \sqrt index: %s`, err)
+	}
+	return mathItem{Markup: "<mroot>" + arg + mrow(items) + "</mroot>"}, nil
+}
+
+// parseDelimiter reads the delimiter after \left, \right, \middle or \big.
+func (p *texParser) parseDelimiter(command string) (string, error) {
+	tok := p.next()
+	if symbol, ok := texDelimiters[tok]; ok {
+		return symbol, nil
+	}
+	if tok == "<" || tok == ">" {
+		return map[string]string{"<": "⟨", ">": "⟩"}[tok], nil
+	}
+	if tok == "" {
+		return "", p.errorf("%s is missing a delimiter", command)
+	}
+	return "", p.errorf("%s%s is not a supported delimiter", command, tok)
+}
+
+func fence(symbol string) string {
+	if symbol == "" {
+		return ""
+	}
+	return `<mo fence="true" stretchy="true">` + html.EscapeString(symbol) + "</mo>"
+}
+
+// parseLeftRight handles \left ... \middle ... \right.
+func (p *texParser) parseLeftRight() (mathItem, error) {
+	open, err := p.parseDelimiter(`\left`)
+	if err != nil {
+		return mathItem{}, err
+	}
+
+	markup := "<mrow>" + fence(open)
+	for {
+		items, err := p.parseList()
+		if err != nil {
+			return mathItem{}, err
+		}
+		markup += joinItems(items)
+
+		switch tok := p.next(); tok {
+		case `\middle`:
+			symbol, err := p.parseDelimiter(tok)
+			if err != nil {
+				return mathItem{}, err
+			}
+			markup += fence(symbol)
+		case `\right`:
+			symbol, err := p.parseDelimiter(tok)
+			if err != nil {
+				return mathItem{}, err
+			}
+			return mathItem{Markup: markup + fence(symbol) + "</mrow>"}, nil
+		case "":
+			return mathItem{}, p.errorf(`\left without matching \right`)
+		default:
+			return mathItem{}, p.unexpected(tok)
+		}
+	}
+}
+
+// parseText handles \text and friends. Only escaped characters are allowed
+// inside, spaces at either end are kept.
+func (p *texParser) parseText(command string, variant string) (mathItem, error) {
+	raw, err := p.readBraced(command)
+	if err != nil {
+		return mathItem{}, err
+	}
+
+	var b strings.Builder
+	runes := []rune(raw)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\\' && i+1 < len(runes) && strings.ContainsRune("{}%$#&_ ", runes[i+1]):
+			i++
+			b.WriteRune(runes[i])
+		case r == '\\':
+			return mathItem{}, p.errorf("commands inside %s are not supported", command)
+		case r == '$':
+			return mathItem{}, p.errorf("math inside %s is not supported", command)
+		case r == '~':
+			b.WriteRune('\u00a0')
+		case r == '{' || r == '}':
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	// MathML trims token contents, non-breaking spaces survive.
+	text := b.String()
+	trimmed := strings.TrimLeft(text, " ")
+	text = strings.Repeat("\u00a0", len(text)-len(trimmed)) + trimmed
+	trimmed = strings.TrimRight(text, " ")
+	text = trimmed + strings.Repeat("\u00a0", len(text)-len(trimmed))
+
+	attrs := ""
+	if variant != "" {
+		attrs = ` mathvariant="` + variant + `"`
+	}
+	return mathItem{Markup: "<mtext" + attrs + ">" + html.EscapeString(text) + "</mtext>"}, nil
+}
+
+// parseEnvironment handles \begin{name} ... \end{name}, the \begin{name}
+// part is already consumed.
+func (p *texParser) parseEnvironment(name string) (mathItem, error) {
+	env, ok := texEnvironments[name]
+	if !ok {
+		return mathItem{}, p.errorf("unsupported environment %s", name)
+	}
+
+	var columns []string
+	if name == "array" {
+		spec, err := p.readBraced(`\begin{array}`)
+		if err != nil {
+			return mathItem{}, err
+		}
+		for _, c := range spec {
+			switch c {
+			case 'l':
+				columns = append(columns, "left")
+			case 'c':
+				columns = append(columns, "center")
+			case 'r':
+				columns = append(columns, "right")
+			case '|', ' ':
+			default:
+				return mathItem{}, p.errorf("unsupported array column %q", c)
+			}
+		}
+	}
+
+	saved := p.display
+	p.display = env.Display
+	defer func() { p.display = saved }()
+
+	var rows [][]string
+	var row []string
+	for {
+		items, err := p.parseList()
+		if err != nil {
+			return mathItem{}, err
+		}
+		row = append(row, joinItems(items))
+
+		tok := p.next()
+		if tok == "&" {
+			continue
+		}
+		if tok == `\\` {
+			// Extra row spacing like \\[1em] is ignored.
+			p.readOptional()
+			rows = append(rows, row)
+			row = nil
+			continue
+		}
+		if tok == `\end` {
+			end, err := p.readBraced(tok)
+			if err != nil {
+				return mathItem{}, err
+			}
+			if end != name {
+				return mathItem{}, p.errorf(`\begin{%s} ended by \end{%s}`, name, end)
+			}
+			if len(row) > 1 || row[0] != "" {
+				rows = append(rows, row)
+			}
+			break
+		}
+		if tok == "" {
+			return mathItem{}, p.errorf(`\begin{%s} without matching \end{%s}`, name, name)
+		}
+		return mathItem{}, p.unexpected(tok)
+	}
+
+	if strings.HasPrefix(name, "equation") {
+		if len(rows) > 1 || (len(rows) == 1 && len(rows[0]) > 1) {
+			return mathItem{}, p.errorf("%s holds a single formula, use aligned for several lines", name)
+		}
+		if len(rows) == 0 {
+			return mathItem{Markup: "<mrow></mrow>"}, nil
+		}
+		return mathItem{Markup: "<mrow>" + rows[0][0] + "</mrow>"}, nil
+	}
+
+	width := 0
+	for _, row := range rows {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+	switch env.Align {
+	case "left":
+		columns = []string{"left"}
+	case "aligned":
+		columns = nil
+		for i := 0; i < width; i++ {
+			columns = append(columns, []string{"right", "left"}[i%2])
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("<mtable")
+	if len(columns) > 0 {
+		b.WriteString(` columnalign="` + strings.Join(columns, " ") + `"`)
+	}
+	if env.Align == "aligned" {
+		b.WriteString(` columnspacing="0em 2em"`)
+	}
+	if env.Display {
+		b.WriteString(` displaystyle="true"`)
+	}
+	b.WriteString(">")
+	for _, row := range rows {
+		b.WriteString("<mtr>")
+		for i, cell := range row {
+			// An empty identifier keeps spacing of a leading relation in
+			// the right column of aligned equations.
+			if env.Align == "aligned" && i%2 == 1 {
+				cell = "<mi></mi>" + cell
+			}
+			b.WriteString("<mtd>" + cell + "</mtd>")
+		}
+		b.WriteString("</mtr>")
+	}
+	b.WriteString("</mtable>")
+
+	markup := b.String()
+	if env.Open != "" || env.Close != "" {
+		markup = "<mrow>" + fence(env.Open) + markup + fence(env.Close) + "</mrow>"
+	}
+	if env.Script {
+		markup = `<mstyle scriptlevel="1">` + markup + "</mstyle>"
+	}
+	return mathItem{Markup: markup}, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTexToMathML(t *testing.T) {
+	tests := []struct {
+		name    string
+		tex     string
+		display bool
+		want    string
+	}{
+		{"fraction", `\frac{a}{b}`, false, `<mfrac><mi>a</mi><mi>b</mi></mfrac>`},
+		{"display fraction", `\dfrac12`, false, `<mstyle displaystyle="true" scriptlevel="0"><mfrac><mn>1</mn><mn>2</mn></mfrac></mstyle>`},
+		{"binomial", `\binom{n}{k}`, false, `<mrow><mo>(</mo><mfrac linethickness="0"><mi>n</mi><mi>k</mi></mfrac><mo>)</mo></mrow>`},
+		{"root", `\sqrt[3]{x}`, false, `<mroot><mi>x</mi><mn>3</mn></mroot>`},
+		{"superscript", `x^2`, false, `<msup><mi>x</mi><mn>2</mn></msup>`},
+		{"both scripts", `x_i^2`, false, `<msubsup><mi>x</mi><mi>i</mi><mn>2</mn></msubsup>`},
+		{"prime", `x'`, false, `<msup><mi>x</mi><mo>′</mo></msup>`},
+		{"inline limits", `\sum_{i=0}^n i`, false, `<mrow><msubsup><mo>∑</mo><mrow><mi>i</mi><mo>=</mo><mn>0</mn></mrow><mi>n</mi></msubsup><mi>i</mi></mrow>`},
+		{"display limits", `\sum_{i=0}^n i`, true, `<mrow><munderover><mo>∑</mo><mrow><mi>i</mi><mo>=</mo><mn>0</mn></mrow><mi>n</mi></munderover><mi>i</mi></mrow>`},
+		{"function", `\sin x`, false, "<mrow><mi>sin</mi><mo>⁡</mo><mi>x</mi></mrow>"},
+		{"pmatrix", `\begin{pmatrix} a & b \\ c & d \end{pmatrix}`, false, `<mrow><mo fence="true" stretchy="true">(</mo><mtable><mtr><mtd><mi>a</mi></mtd><mtd><mi>b</mi></mtd></mtr><mtr><mtd><mi>c</mi></mtd><mtd><mi>d</mi></mtd></mtr></mtable><mo fence="true" stretchy="true">)</mo></mrow>`},
+		{"cases", `\begin{cases} 1 & x > 0 \\ 0 & \text{else} \end{cases}`, false, `<mrow><mo fence="true" stretchy="true">{</mo><mtable columnalign="left"><mtr><mtd><mn>1</mn></mtd><mtd><mi>x</mi><mo>&gt;</mo><mn>0</mn></mtd></mtr><mtr><mtd><mn>0</mn></mtd><mtd><mtext>else</mtext></mtd></mtr></mtable></mrow>`},
+		{"aligned", `\begin{aligned} a &= b \\ &= c \end{aligned}`, true, `<mtable columnalign="right left" columnspacing="0em 2em" displaystyle="true"><mtr><mtd><mi>a</mi></mtd><mtd><mi></mi><mo>=</mo><mi>b</mi></mtd></mtr><mtr><mtd></mtd><mtd><mi></mi><mo>=</mo><mi>c</mi></mtd></mtr></mtable>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := texToMathML(tt.tex, tt.display)
+			if err != nil {
+				t.Fatalf("texToMathML(%q): %v", tt.tex, err)
+			}
+
+			open := `<math xmlns="http://www.w3.org/1998/Math/MathML">`
+			if tt.display {
+				open = `<math xmlns="http://www.w3.org/1998/Math/MathML" display="block">`
+			}
+			want := open + tt.want + `</math>`
+			if got != want {
+				t.Errorf("texToMathML(%q)\n got: %s\nwant: %s", tt.tex, got, want)
+			}
+		})
+	}
+}
+
+func TestTexToMathMLErrors(t *testing.T) {
+	tests := []struct {
+		tex     string
+		pos     int
+		message string
+	}{
+		{`\frac{a}`, 8, `\frac is missing an argument`},
+		{`x^`, 2, `^ is missing an argument`},
+		{`x^2^3`, 4, `double superscript, use braces to group scripts`},
+		{`x}`, 1, `unexpected }`},
+		{`a & b`, 2, `& is only allowed inside matrices and aligned environments`},
+		{`\foo`, 4, `unsupported command \foo`},
+		{`\left( x`, 8, `\left without matching \right`},
+		{`\right)`, 0, `\right without matching \left`},
+		{`\begin{nope} a \end{nope}`, 12, `unsupported environment nope`},
+		{`\begin{matrix} a`, 16, `\begin{matrix} without matching \end{matrix}`},
+		{`\begin{pmatrix} a \end{bmatrix}`, 31, `\begin{pmatrix} ended by \end{bmatrix}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.ReplaceAll(tt.tex, `\`, ""), func(t *testing.T) {
+			_, err := texToMathML(tt.tex, false)
+			var texErr *texError
+			if !errors.As(err, &texErr) {
+				t.Fatalf("texToMathML(%q) error = %v, want a texError", tt.tex, err)
+			}
+			if texErr.Pos != tt.pos || texErr.Message != tt.message {
+				t.Errorf("texToMathML(%q) error = %d %q, want %d %q", tt.tex, texErr.Pos, texErr.Message, tt.pos, tt.message)
+			}
+		})
+	}
+}
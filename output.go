@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Output is a single rendered file the build writes into public. Source
+// names what produced it for error messages.
+type Output struct {
+	URL     string
+	Content []byte
+	Source  string
+}
+
+// OutputCollisionError reports several sources writing the same file.
+type OutputCollisionError struct {
+	URL     string
+	Sources []string
+}
+
+func (e OutputCollisionError) Error() string {
+	return fmt.Sprintf("public/%s is written by %s", e.URL, strings.Join(e.Sources, ", "))
+}
+
+// findCollisions returns an error for every file in public that more than
+// one output would write, in order of first appearance.
+func findCollisions(outputs []Output) []error {
+	var order []string
+	sources := map[string][]string{}
+	for _, output := range outputs {
+		url := path.Clean(output.URL)
+		if _, ok := sources[url]; !ok {
+			order = append(order, url)
+		}
+		sources[url] = append(sources[url], output.Source)
+	}
+
+	var errs []error
+	for _, url := range order {
+		if len(sources[url]) > 1 {
+			errs = append(errs, OutputCollisionError{URL: url, Sources: sources[url]})
+		}
+	}
+	return errs
+}
+
+// writeOutputs writes rendered files into public, skipping unchanged ones.
+func writeOutputs(publicRoot string, outputs []Output) error {
+	for _, output := range outputs {
+		outFilepath := path.Join(publicRoot, output.URL)
+		if err := os.MkdirAll(path.Dir(outFilepath), 0755); err != nil {
+			return err
+		}
+
+		written, err := writeIfChanged(outFilepath, output.Content)
+		if err != nil {
+			return err
+		}
+		if written {
+			log.Println("Wrote", outFilepath)
+		}
+	}
+	return nil
+}
+
+// staticFileList returns files in static folder relative to it.
+func staticFileList(projectRoot string) ([]string, error) {
+	staticRoot := path.Join(projectRoot, "static")
+	var files []string
+	err := filepath.Walk(staticRoot, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			rel, err := filepath.Rel(staticRoot, file)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return files, err
+}
+
+func manifestFilepath(projectRoot string) string {
+	return path.Join(projectRoot, ".jbmafp", "manifest.json")
+}
+
+// loadManifest returns files in public produced by the previous build.
+func loadManifest(projectRoot string) []string {
+	var files []string
+	content, err := os.ReadFile(manifestFilepath(projectRoot))
+	if err != nil {
+		return nil
+	}
+	if err := json.Unmarshal(content, &files); err != nil {
+		return nil
+	}
+	return files
+}
+
+// saveManifest records files in public produced by this build.
+func saveManifest(projectRoot string, files []string) error {
+	sort.Strings(files)
+	content, err := json.MarshalIndent(files, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(manifestFilepath(projectRoot)), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(manifestFilepath(projectRoot), content, 0644)
+}
+
+// removeOrphans deletes files the previous build produced but this one did
+// not, together with folders that became empty. Files in public that were
+// never produced by a build are left alone.
+func removeOrphans(publicRoot string, previous []string, current []string) {
+	produced := map[string]bool{}
+	for _, file := range current {
+		produced[file] = true
+	}
+
+	for _, file := range previous {
+		if produced[file] {
+			continue
+		}
+
+		orphan := path.Join(publicRoot, file)
+		if err := os.Remove(orphan); err != nil && !os.IsNotExist(err) {
+			log.Println("Could not remove", orphan, err)
+			continue
+		}
+		log.Println("Removed", orphan)
+
+		// Remove parent folders until one is not empty.
+		for dir := path.Dir(orphan); dir != publicRoot && len(dir) > len(publicRoot); dir = path.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Default pattern for pages after the first one, relative to the listing.
+const defaultPaginationPath = "page/:num/index.html"
+
+// Paginator is one page of a paginated listing. Prev and Next are nil on
+// the first and last page.
+type Paginator struct {
+	Pages        []Page
+	Number       int
+	Total        int
+	RelPermalink string
+	Prev         *Paginator
+	Next         *Paginator
+}
+
+// paginationBase returns the folder other pages of a listing live in, e.g.
+// "" for index.html, "tags/go" for tags/go.html, "tags" for tags/index.html
+// and "index" for index.xml. Only index.html stands for its folder.
+func paginationBase(url string) string {
+	base := strings.TrimSuffix(url, path.Ext(url))
+	if path.Base(url) == "index.html" {
+		base = path.Dir(base)
+	}
+	if base == "." || base == "/" {
+		return ""
+	}
+	return strings.Trim(base, "/")
+}
+
+// paginationPath fills the pattern for page num of a listing. Listings
+// that are not HTML keep their extension, so page 2 of index.xml is
+// index/page/2/index.xml with the default pattern.
+func paginationPath(url string, pattern string, num int) string {
+	pageURL := strings.ReplaceAll(pattern, ":num", strconv.Itoa(num))
+	if ext := path.Ext(url); ext != "" && ext != ".html" {
+		if strings.HasSuffix(pageURL, "/") {
+			pageURL += "index"
+		} else {
+			pageURL = strings.TrimSuffix(pageURL, path.Ext(pageURL))
+		}
+		pageURL += ext
+	}
+	return path.Join(paginationBase(url), pageURL)
+}
+
+// paginate splits pages into chunks of size. The first chunk keeps the
+// listing url, others follow the pattern. Size 0 puts everything on a
+// single page.
+func paginate(pages []Page, size int, url string, pattern string) []*Paginator {
+	if pattern == "" {
+		pattern = defaultPaginationPath
+	}
+	if size <= 0 {
+		size = len(pages)
+	}
+
+	total := 1
+	if size > 0 && len(pages) > size {
+		total = (len(pages) + size - 1) / size
+	}
+
+	paginators := make([]*Paginator, total)
+	for i := range paginators {
+		start := i * size
+		end := start + size
+		if end > len(pages) {
+			end = len(pages)
+		}
+
+		relPermalink := url
+		if i > 0 {
+			relPermalink = paginationPath(url, pattern, i+1)
+		}
+
+		paginators[i] = &Paginator{
+			Pages:        pages[start:end],
+			Number:       i + 1,
+			Total:        total,
+			RelPermalink: relPermalink,
+		}
+	}
+
+	for i, paginator := range paginators {
+		if i > 0 {
+			paginator.Prev = paginators[i-1]
+		}
+		if i < len(paginators)-1 {
+			paginator.Next = paginators[i+1]
+		}
+	}
+	return paginators
+}
+
+// pagesOfTypes returns pages with one of the types, all pages when types
+// is empty.
+func pagesOfTypes(pages []Page, types []string) []Page {
+	var filtered []Page
+	for _, page := range pages {
+		if len(types) == 0 || containsString(types, page.Type) {
+			filtered = append(filtered, page)
+		}
+	}
+	return filtered
+}
+
+func containsString(items []string, value string) bool {
+	for _, item := range items {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gosimple/slug"
+)
+
+// expandPermalink fills a permalink pattern like /:type/:year/:slug/ for a
+// page. Supported tokens are :type, :year, :month, :day, :slug and
+// :filename. Slug comes from the `slug` field or is made from the title.
+func expandPermalink(pattern string, metaData map[string]interface{}, created time.Time, file string) string {
+	pageSlug, _ := metaData["slug"].(string)
+	if pageSlug == "" {
+		pageSlug = slug.Make(metaData["title"].(string))
+	}
+
+	replacer := strings.NewReplacer(
+		":type", slug.Make(metaData["type"].(string)),
+		":year", created.Format("2006"),
+		":month", created.Format("01"),
+		":day", created.Format("02"),
+		":slug", pageSlug,
+		":filename", strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)),
+	)
+	return replacer.Replace(pattern)
+}
+
+// normalizeURL cleans a page url so it is relative to public. Trailing
+// slash is kept since it means the page is written as index.html inside
+// that folder.
+func normalizeURL(url string) (string, error) {
+	isDir := strings.HasSuffix(url, "/")
+	cleaned := path.Clean("/" + url)
+	if cleaned == "/" {
+		return "", fmt.Errorf("%q does not point to a file or folder", url)
+	}
+
+	cleaned = strings.TrimPrefix(cleaned, "/")
+	if isDir {
+		cleaned += "/"
+	}
+	return cleaned, nil
+}
+
+// outputPath returns the file in public a permalink is written to.
+func outputPath(relPermalink string) string {
+	if strings.HasSuffix(relPermalink, "/") {
+		return relPermalink + "index.html"
+	}
+	return relPermalink
+}
@@ -0,0 +1,33 @@
+package main
+
+import "sync"
+
+// parallel calls fn for every index in [0, n) using at most jobs goroutines.
+// Results should be stored by index so the outcome does not depend on
+// scheduling order.
+func parallel(jobs int, n int, fn func(i int)) {
+	if jobs < 1 {
+		jobs = 1
+	}
+	if jobs > n {
+		jobs = n
+	}
+
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+}
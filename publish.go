@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+)
+
+// Page status values. Public pages have an empty status.
+const (
+	StatusDraft     = "draft"
+	StatusScheduled = "scheduled"
+	StatusExpired   = "expired"
+)
+
+// pageStatus tells why a page is not public at the given time.
+func pageStatus(page Page, now time.Time) string {
+	switch {
+	case page.Draft:
+		return StatusDraft
+	case page.Created.After(now):
+		return StatusScheduled
+	case !page.Expires.IsZero() && !page.Expires.After(now):
+		return StatusExpired
+	default:
+		return ""
+	}
+}
+
+// publishedPages drops drafts, scheduled and expired pages unless the build
+// options ask to preview them. Kept pages get their Status set.
+func publishedPages(projectRoot string, pages []Page, options BuildOptions, now time.Time) []Page {
+	kept := []Page{}
+	for _, page := range pages {
+		page.Status = pageStatus(page, now)
+
+		include := page.Status == "" ||
+			(page.Status == StatusDraft && options.Drafts) ||
+			(page.Status == StatusScheduled && options.Future) ||
+			(page.Status == StatusExpired && options.Expired)
+
+		if !include {
+			log.Printf("Skipped %s page %s\n", page.Status, relativeFilepath(projectRoot, page.Filepath))
+			continue
+		}
+		kept = append(kept, page)
+	}
+	return kept
+}
+
+// Banner text shown on previewed pages by status.
+var statusBanners = map[string]string{
+	StatusDraft:     "Draft, this page is not public",
+	StatusScheduled: "Scheduled, this page is not public yet",
+	StatusExpired:   "Expired, this page is not public anymore",
+}
+
+var bodyTagPattern = regexp.MustCompile(`(?i)<body[^>]*>`)
+var headEndPattern = regexp.MustCompile(`(?i)</head>`)
+
+// injectStatusBanner puts a visible banner on top of pages that are only
+// built because of --drafts, --future or --expired.
+func injectStatusBanner(content []byte, status string) []byte {
+	banner := []byte(fmt.Sprintf(`<div style="position:sticky;top:0;z-index:99999;padding:.5em;background:#c00;color:#fff;font:bold 14px sans-serif;text-align:center">%s</div>`, statusBanners[status]))
+
+	for _, pattern := range []*regexp.Regexp{bodyTagPattern, headEndPattern} {
+		if loc := pattern.FindIndex(content); loc != nil {
+			out := append([]byte{}, content[:loc[1]]...)
+			out = append(out, banner...)
+			return append(out, content[loc[1]:]...)
+		}
+	}
+	return append(banner, content...)
+}
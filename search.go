@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/DavidBelicza/TextRank/v2/convert"
+)
+
+// Weights of a term depending on where in the page it was found.
+const (
+	searchWeightTitle   = 10
+	searchWeightSummary = 3
+	searchWeightText    = 1
+)
+
+// Suffixes stripped by the search stemmer, tried in order. The same list
+// lives in files/search.html so queries get stemmed the same way.
+var searchSuffixes = []struct {
+	Suffix      string
+	Replacement string
+}{
+	{"ingly", ""},
+	{"edly", ""},
+	{"ments", ""},
+	{"ment", ""},
+	{"ness", ""},
+	{"ings", ""},
+	{"ing", ""},
+	{"ies", "y"},
+	{"ied", "y"},
+	{"ed", ""},
+	{"ly", ""},
+	{"s", ""},
+}
+
+// searchDocument is a page in the index. Keys are short to keep it small.
+type searchDocument struct {
+	Title   string `json:"t"`
+	URL     string `json:"u"`
+	Type    string `json:"y"`
+	Summary string `json:"s"`
+}
+
+// searchIndex maps stemmed terms to a flat list of document index and
+// score pairs: [doc, score, doc, score, ...].
+type searchIndex struct {
+	Documents []searchDocument `json:"docs"`
+	Terms     map[string][]int `json:"terms"`
+}
+
+// searchTokens splits text into lowercase words.
+func searchTokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(html.UnescapeString(text)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// searchStem strips a common suffix leaving at least three characters.
+func searchStem(word string) string {
+	runes := []rune(word)
+	for _, s := range searchSuffixes {
+		if !strings.HasSuffix(word, s.Suffix) {
+			continue
+		}
+		if s.Suffix == "s" && (strings.HasSuffix(word, "ss") || strings.HasSuffix(word, "us") || strings.HasSuffix(word, "is")) {
+			return word
+		}
+		if len(runes)-len([]rune(s.Suffix)) < 3 {
+			continue
+		}
+		return strings.TrimSuffix(word, s.Suffix) + s.Replacement
+	}
+	return word
+}
+
+// searchTerms returns stemmed terms of text without stop words.
+func searchTerms(text string, language convert.Language) []string {
+	var terms []string
+	for _, token := range searchTokens(text) {
+		if language.IsStopWord(token) {
+			continue
+		}
+		terms = append(terms, searchStem(token))
+	}
+	return terms
+}
+
+// buildSearchIndex writes a JSON search index of published pages. Stop
+// words are dropped using the language of each page.
+func buildSearchIndex(config Config, pages []Page, stopwords Stopwords) ([]byte, error) {
+	languages := map[string]convert.Language{}
+	index := searchIndex{
+		Documents: []searchDocument{},
+		Terms:     map[string][]int{},
+	}
+
+	for _, page := range pagesOfTypes(pages, config.Search.Type) {
+		// Same as the sitemap, previewed pages are not indexed.
+		if page.Draft || page.Status != "" {
+			continue
+		}
+
+		language, ok := languages[page.Language]
+		if !ok {
+			language = stopwords.Language(page.Language)
+			languages[page.Language] = language
+		}
+
+		scores := map[string]int{}
+		for _, term := range searchTerms(page.Title, language) {
+			scores[term] += searchWeightTitle
+		}
+		for _, term := range searchTerms(page.Summary, language) {
+			scores[term] += searchWeightSummary
+		}
+		for _, term := range searchTerms(page.Text, language) {
+			scores[term] += searchWeightText
+		}
+
+		// Sorted so the output does not depend on map order.
+		terms := make([]string, 0, len(scores))
+		for term := range scores {
+			terms = append(terms, term)
+		}
+		sort.Strings(terms)
+
+		doc := len(index.Documents)
+		for _, term := range terms {
+			index.Terms[term] = append(index.Terms[term], doc, scores[term])
+		}
+
+		index.Documents = append(index.Documents, searchDocument{
+			Title:   page.Title,
+			URL:     page.RelPermalink,
+			Type:    page.Type,
+			Summary: page.Summary,
+		})
+	}
+
+	return json.Marshal(index)
+}
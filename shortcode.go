@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark"
+)
+
+// Matches {{< name key="value" >}}, {{< /name >}} and {{< name />}}. The
+// {{</* name */>}} form is written out literally, which is handy for docs.
+var shortcodePattern = regexp.MustCompile(`\{\{<\s*(/\*)?\s*(/?)([A-Za-z0-9_-]+)((?:[^>]|>[^}])*?)\s*(/?)\s*(\*/)?\s*>\}\}`)
+
+// Matches a single key="value", key='value' or key=value argument.
+var shortcodeArgPattern = regexp.MustCompile(`^\s*([A-Za-z0-9_-]+)\s*=\s*("(?:[^"\\]|\\.)*"|'[^']*'|[^\s"']+)`)
+
+// ShortcodePayload is the data every shortcode template gets. Inner is the
+// rendered markdown between the opening and closing tag of paired shortcodes.
+type ShortcodePayload struct {
+	Name   string
+	Params map[string]string
+	Inner  template.HTML
+	Page   Page
+	Config Config
+}
+
+// Get returns a parameter or an empty string when it was not given.
+func (p ShortcodePayload) Get(key string) string {
+	return p.Params[key]
+}
+
+// Shortcodes holds templates from templates/shortcodes. A shortcode is
+// paired when its template uses .Inner.
+type Shortcodes struct {
+	templates map[string]*template.Template
+	paired    map[string]bool
+}
+
+// shortcodeTag is a single {{< ... >}} found in markdown.
+type shortcodeTag struct {
+	Start, End int
+	Name       string
+	Args       string
+	Closing    bool
+	SelfClose  bool
+	Literal    bool
+}
+
+// loadShortcodes parses every template in templates/shortcodes with the
+// same filters pages get. Their sources come back too, since editing a
+// shortcode has to invalidate every cached page.
+func loadShortcodes(projectRoot string, filters template.FuncMap) (*Shortcodes, []byte, error) {
+	shortcodes := &Shortcodes{
+		templates: map[string]*template.Template{},
+		paired:    map[string]bool{},
+	}
+
+	dir := path.Join(projectRoot, "templates", "shortcodes")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return shortcodes, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	var salt cacheSalt
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".html" {
+			continue
+		}
+		content, err := os.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		name := strings.TrimSuffix(entry.Name(), ".html")
+		t, err := template.New(entry.Name()).Funcs(filters).Parse(string(content))
+		if err != nil {
+			return nil, nil, err
+		}
+		shortcodes.templates[name] = t
+		shortcodes.paired[name] = strings.Contains(string(content), ".Inner")
+
+		salt.AddFile(entry.Name(), content)
+	}
+
+	return shortcodes, salt.Bytes(), nil
+}
+
+// findShortcodeTags returns all shortcode tags in source in order.
+func findShortcodeTags(source []byte) []shortcodeTag {
+	var tags []shortcodeTag
+	for _, m := range shortcodePattern.FindAllSubmatchIndex(source, -1) {
+		tags = append(tags, shortcodeTag{
+			Start:     m[0],
+			End:       m[1],
+			Literal:   m[2] >= 0 && m[12] >= 0,
+			Closing:   m[5] > m[4],
+			Name:      string(source[m[6]:m[7]]),
+			Args:      string(source[m[8]:m[9]]),
+			SelfClose: m[11] > m[10],
+		})
+	}
+	return tags
+}
+
+// parseShortcodeArgs parses key="value" pairs.
+func parseShortcodeArgs(args string) (map[string]string, error) {
+	params := map[string]string{}
+	rest := strings.TrimSpace(args)
+	for rest != "" {
+		m := shortcodeArgPattern.FindStringSubmatch(rest)
+		if m == nil {
+			return nil, fmt.Errorf("cannot parse arguments %q, use key=\"value\"", rest)
+		}
+
+		value := m[2]
+		switch value[0] {
+		case '"':
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse value of %s: %s", m[1], err)
+			}
+			value = unquoted
+		case '\'':
+			value = value[1 : len(value)-1]
+		}
+		params[m[1]] = value
+		rest = strings.TrimSpace(rest[len(m[0]):])
+	}
+	return params, nil
+}
+
+// shortcodeExpansion replaces shortcodes in one markdown file. Rendered
+// shortcodes are swapped for placeholders so goldmark leaves their HTML
+// alone, Replace puts the HTML back after conversion.
+type shortcodeExpansion struct {
+	shortcodes   *Shortcodes
+	md           goldmark.Markdown
+	page         Page
+	config       Config
+	source       []byte
+	placeholders []string
+	rendered     []string
+}
+
+// Expand replaces every shortcode in body, which starts at offset in the
+// markdown source, with a placeholder.
+func (e *shortcodeExpansion) Expand(body []byte, offset int) ([]byte, error) {
+	tags := findShortcodeTags(body)
+	var out bytes.Buffer
+	last := 0
+
+	for i := 0; i < len(tags); i++ {
+		tag := tags[i]
+		out.Write(body[last:tag.Start])
+		last = tag.End
+
+		if tag.Literal {
+			literal := strings.Replace(string(body[tag.Start:tag.End]), "/*", "", 1)
+			out.WriteString(strings.Replace(literal, "*/", "", 1))
+			continue
+		}
+
+		line := lineAt(e.source, offset+tag.Start)
+		if tag.Closing {
+			return nil, fmt.Errorf("line %d: closing shortcode %q without opening one", line, tag.Name)
+		}
+
+		t, ok := e.shortcodes.templates[tag.Name]
+		if !ok {
+			return nil, fmt.Errorf("line %d: unknown shortcode %q, add templates/shortcodes/%s.html", line, tag.Name, tag.Name)
+		}
+
+		params, err := parseShortcodeArgs(tag.Args)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: shortcode %q: %w", line, tag.Name, err)
+		}
+
+		payload := ShortcodePayload{
+			Name:   tag.Name,
+			Params: params,
+			Page:   e.page,
+			Config: e.config,
+		}
+
+		if e.shortcodes.paired[tag.Name] && !tag.SelfClose {
+			// Find the matching closing tag, same-named shortcodes may nest.
+			closing, depth := -1, 0
+			for j := i + 1; j < len(tags) && closing < 0; j++ {
+				if tags[j].Name != tag.Name || tags[j].Literal {
+					continue
+				}
+				switch {
+				case tags[j].Closing && depth == 0:
+					closing = j
+				case tags[j].Closing:
+					depth--
+				case !tags[j].SelfClose:
+					depth++
+				}
+			}
+			if closing < 0 {
+				return nil, fmt.Errorf("line %d: shortcode %q is missing {{< /%s >}}", line, tag.Name, tag.Name)
+			}
+
+			inner, err := e.Expand(body[tag.End:tags[closing].Start], offset+tag.End)
+			if err != nil {
+				return nil, err
+			}
+			var buf bytes.Buffer
+			if err := e.md.Convert(inner, &buf); err != nil {
+				return nil, fmt.Errorf("line %d: shortcode %q: %w", line, tag.Name, err)
+			}
+			payload.Inner = template.HTML(e.Replace(buf.String()))
+
+			last = tags[closing].End
+			i = closing
+		}
+
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, payload); err != nil {
+			return nil, fmt.Errorf("line %d: shortcode %q: %w", line, tag.Name, err)
+		}
+
+		placeholder := fmt.Sprintf("JBMAFPSHORTCODE%04dX", len(e.placeholders))
+		e.placeholders = append(e.placeholders, placeholder)
+		e.rendered = append(e.rendered, strings.TrimSpace(buf.String()))
+		out.WriteString(placeholder)
+	}
+
+	out.Write(body[last:])
+	return out.Bytes(), nil
+}
+
+// Replace swaps placeholders in converted HTML for rendered shortcodes. A
+// shortcode alone on a line does not end up wrapped in a paragraph.
+func (e *shortcodeExpansion) Replace(htmlContent string) string {
+	for i := range e.placeholders {
+		htmlContent = strings.ReplaceAll(htmlContent, "<p>"+e.placeholders[i]+"</p>", e.rendered[i])
+		htmlContent = strings.ReplaceAll(htmlContent, e.placeholders[i], e.rendered[i])
+	}
+	return htmlContent
+}
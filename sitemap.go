@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Limit of URLs in a single sitemap file set by sitemaps.org.
+const sitemapMaxURLs = 50000
+
+const sitemapXmlns = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	Lastmod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapEntry struct {
+	Loc     string `xml:"loc"`
+	Lastmod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name       `xml:"sitemapindex"`
+	Xmlns    string         `xml:"xmlns,attr"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+// absoluteURL joins base url and a relative permalink, escaping the path.
+func absoluteURL(baseURL string, relPermalink string) string {
+	escaped := (&url.URL{Path: strings.TrimLeft(relPermalink, "/")}).EscapedPath()
+	return strings.TrimRight(baseURL, "/") + "/" + escaped
+}
+
+func formatLastmod(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func marshalSitemap(v interface{}) ([]byte, error) {
+	content, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), content...), nil
+}
+
+// buildSitemap returns sitemap.xml for the index and all public pages that
+// did not opt out with `sitemap: false`. Previewed drafts, scheduled and
+// expired pages are never listed. Bigger sites get sitemap.xml as an index
+// of sitemap-1.xml, sitemap-2.xml, ...
+func buildSitemap(config Config, pages []Page) ([]Output, error) {
+	urls := []sitemapURL{}
+	lastmods := []time.Time{}
+	var newest time.Time
+	for _, page := range pages {
+		if page.Status != "" || !page.Sitemap {
+			continue
+		}
+		if page.Lastmod.After(newest) {
+			newest = page.Lastmod
+		}
+		urls = append(urls, sitemapURL{
+			Loc:     absoluteURL(config.BaseURL, page.RelPermalink),
+			Lastmod: formatLastmod(page.Lastmod),
+		})
+		lastmods = append(lastmods, page.Lastmod)
+	}
+
+	// Index goes first and changes whenever any page does.
+	urls = append([]sitemapURL{{
+		Loc:     absoluteURL(config.BaseURL, ""),
+		Lastmod: formatLastmod(newest),
+	}}, urls...)
+	lastmods = append([]time.Time{newest}, lastmods...)
+
+	if len(urls) <= sitemapMaxURLs {
+		content, err := marshalSitemap(sitemapURLSet{Xmlns: sitemapXmlns, URLs: urls})
+		if err != nil {
+			return nil, err
+		}
+		return []Output{{URL: "sitemap.xml", Content: content, Source: "sitemap"}}, nil
+	}
+
+	outputs := []Output{}
+	index := sitemapIndex{Xmlns: sitemapXmlns}
+	for start := 0; start < len(urls); start += sitemapMaxURLs {
+		end := start + sitemapMaxURLs
+		if end > len(urls) {
+			end = len(urls)
+		}
+
+		var chunkNewest time.Time
+		for _, lastmod := range lastmods[start:end] {
+			if lastmod.After(chunkNewest) {
+				chunkNewest = lastmod
+			}
+		}
+
+		name := fmt.Sprintf("sitemap-%d.xml", len(index.Sitemaps)+1)
+		content, err := marshalSitemap(sitemapURLSet{Xmlns: sitemapXmlns, URLs: urls[start:end]})
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, Output{URL: name, Content: content, Source: "sitemap"})
+		index.Sitemaps = append(index.Sitemaps, sitemapEntry{
+			Loc:     absoluteURL(config.BaseURL, name),
+			Lastmod: formatLastmod(chunkNewest),
+		})
+	}
+
+	content, err := marshalSitemap(index)
+	if err != nil {
+		return nil, err
+	}
+	return append(outputs, Output{URL: "sitemap.xml", Content: content, Source: "sitemap"}), nil
+}
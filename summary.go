@@ -0,0 +1,175 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/DavidBelicza/TextRank/v2"
+	"github.com/DavidBelicza/TextRank/v2/convert"
+	"github.com/DavidBelicza/TextRank/v2/rank"
+)
+
+// Everything before this comment in a markdown file becomes the summary.
+const summaryDivider = "<!--more-->"
+
+// Summary modes selectable in config.yaml.
+const (
+	SummaryTextRank  = "textrank"
+	SummaryParagraph = "paragraph"
+	SummaryText      = "text"
+)
+
+// Default length of summaries in text mode.
+const defaultSummaryLength = 200
+
+var firstParagraphPattern = regexp.MustCompile(`(?s)<p(?:\s[^>]*)?>(.*?)</p>`)
+var whitespacePattern = regexp.MustCompile(`\s+`)
+
+// plainText strips tags from HTML and collapses whitespace.
+func plainText(htmlString string) string {
+	text := html.UnescapeString(cleanHTMLTags(htmlString))
+	return strings.TrimSpace(whitespacePattern.ReplaceAllString(text, " "))
+}
+
+// textRankSummary returns the highest ranked sentences in reading order.
+// Stopwords of language are left out when ranking.
+func textRankSummary(text string, count int, language convert.Language) string {
+	if count <= 0 {
+		count = 1
+	}
+
+	tr := textrank.NewTextRank()
+	rule := textrank.NewDefaultRule()
+	algorithmDef := textrank.NewDefaultAlgorithm()
+	tr.Populate(text, language, rule)
+	tr.Ranking(algorithmDef)
+
+	sentences := rankedSentences(tr, count)
+	if len(sentences) == 0 {
+		sentences = textrank.FindSentencesFrom(tr, 0, count)
+	}
+	sort.Slice(sentences, func(i, j int) bool {
+		return sentences[i].ID < sentences[j].ID
+	})
+
+	var parts []string
+	for _, s := range sentences {
+		parts = append(parts, strings.TrimSpace(strings.ReplaceAll(s.Value, "\n", " ")))
+	}
+	return strings.Join(parts, " ")
+}
+
+// rankedSentences returns sentences containing the highest weighted phrases
+// like textrank.FindSentencesByRelationWeight. That one sorts phrases coming
+// out of a map without breaking ties, so equally weighted phrases are taken
+// in random order and the summary changes between builds. Ties here go to
+// the phrase appearing first in the text. When all phrases occur equally
+// often TextRank normalizes their weights to NaN, those count as 0.
+func rankedSentences(tr *textrank.TextRank, count int) []rank.Sentence {
+	ranks := tr.GetRankData()
+
+	type phrase struct {
+		weight      float32
+		first       int
+		left, right int
+		sentenceIDs []int
+	}
+	var phrases []phrase
+	for left, row := range ranks.Relation.Node {
+		for right, score := range row {
+			if len(score.SentenceIDs) == 0 {
+				continue
+			}
+			first := score.SentenceIDs[0]
+			for _, id := range score.SentenceIDs {
+				if id < first {
+					first = id
+				}
+			}
+			weight := score.Weight
+			if math.IsNaN(float64(weight)) {
+				weight = 0
+			}
+			phrases = append(phrases, phrase{weight, first, left, right, score.SentenceIDs})
+		}
+	}
+	sort.Slice(phrases, func(i, j int) bool {
+		a, b := phrases[i], phrases[j]
+		if a.weight != b.weight {
+			return a.weight > b.weight
+		}
+		if a.first != b.first {
+			return a.first < b.first
+		}
+		if a.left != b.left {
+			return a.left < b.left
+		}
+		return a.right < b.right
+	})
+
+	var sentences []rank.Sentence
+	seen := map[int]bool{}
+	for _, p := range phrases {
+		ids := append([]int{}, p.sentenceIDs...)
+		sort.Ints(ids)
+		for _, id := range ids {
+			if len(sentences) >= count {
+				return sentences
+			}
+			if !seen[id] {
+				seen[id] = true
+				sentences = append(sentences, rank.Sentence{ID: id, Value: ranks.SentenceMap[id]})
+			}
+		}
+	}
+	return sentences
+}
+
+// truncateText cuts text to at most length characters on a word boundary.
+func truncateText(text string, length int) string {
+	if length <= 0 {
+		length = defaultSummaryLength
+	}
+
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+
+	cut := string(runes[:length])
+	if idx := strings.LastIndex(cut, " "); idx > 0 {
+		cut = cut[:idx]
+	}
+	return strings.TrimRight(cut, " ,.;:") + "…"
+}
+
+// summarize picks the page summary as plain text and HTML. A `summary`
+// front matter field wins, then the <!--more--> divider, then the mode
+// configured in config.yaml.
+func summarize(config ConfigSummary, language convert.Language, htmlContent string, metaData map[string]interface{}) (string, template.HTML) {
+	if summary, ok := metaData["summary"].(string); ok && summary != "" {
+		return summary, template.HTML(html.EscapeString(summary))
+	}
+
+	if idx := strings.Index(htmlContent, summaryDivider); idx >= 0 {
+		summaryHTML := strings.TrimSpace(htmlContent[:idx])
+		return plainText(summaryHTML), template.HTML(summaryHTML)
+	}
+
+	var summary string
+	switch config.Mode {
+	case SummaryParagraph:
+		if match := firstParagraphPattern.FindStringSubmatch(htmlContent); match != nil {
+			return plainText(match[1]), template.HTML(match[0])
+		}
+	case SummaryText:
+		summary = truncateText(plainText(htmlContent), config.Length)
+	default:
+		summary = textRankSummary(plainText(htmlContent), config.Sentences, language)
+	}
+	return summary, template.HTML(html.EscapeString(summary))
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/gosimple/slug"
+)
+
+// Tag groups all pages that list it under `tags`.
+type Tag struct {
+	Name         string
+	Slug         string
+	RelPermalink string
+	Pages        []Page
+}
+
+// tagsFromMeta returns the `tags` front matter list. Validation makes sure
+// it only holds strings.
+func tagsFromMeta(metaData map[string]interface{}) []string {
+	var tags []string
+	items, _ := metaData["tags"].([]interface{})
+	for _, item := range items {
+		if tag, ok := item.(string); ok && tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// collectTags builds the tag map keyed by tag slug. Tags that only differ
+// in case or punctuation end up in the same slug and are merged. Pages keep
+// the order of the pages slice.
+func collectTags(pages []Page) map[string]*Tag {
+	tags := map[string]*Tag{}
+	for _, page := range pages {
+		seen := map[string]bool{}
+		for _, name := range page.Tags {
+			tagSlug := slug.Make(name)
+			if tagSlug == "" || seen[tagSlug] {
+				continue
+			}
+			seen[tagSlug] = true
+
+			tag, ok := tags[tagSlug]
+			if !ok {
+				tag = &Tag{
+					Name:         name,
+					Slug:         tagSlug,
+					RelPermalink: "tags/" + tagSlug + ".html",
+				}
+				tags[tagSlug] = tag
+			}
+			tag.Pages = append(tag.Pages, page)
+		}
+	}
+	return tags
+}
+
+// sortedTags returns tags ordered by slug for stable rendering.
+func sortedTags(tags map[string]*Tag) []*Tag {
+	list := make([]*Tag, 0, len(tags))
+	for _, tag := range tags {
+		list = append(list, tag)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Slug < list[j].Slug
+	})
+	return list
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tdewolff/minify/v2"
+	mcss "github.com/tdewolff/minify/v2/css"
+	mhtml "github.com/tdewolff/minify/v2/html"
+	mjs "github.com/tdewolff/minify/v2/js"
+	mjson "github.com/tdewolff/minify/v2/json"
+	mxml "github.com/tdewolff/minify/v2/xml"
+)
+
+// Payload is the data every template gets. Page is only set when rendering
+// a single page, Tag only when rendering a tag listing and Paginator only
+// for listings (index, tag pages and extras).
+type Payload struct {
+	Config    Config
+	Page      Page
+	Pages     []Page
+	Tags      map[string]*Tag
+	Tag       *Tag
+	Paginator *Paginator
+}
+
+// TemplateRegistry parses base.html and includes once per build and keeps a
+// template set for every page type, so pages only need a cheap clone.
+type TemplateRegistry struct {
+	projectRoot string
+	base        *template.Template
+	shared      map[string]string
+
+	mu   sync.Mutex
+	sets map[string]*template.Template
+}
+
+func includeTemplateList(projectRoot string) ([]string, error) {
+	var templateFiles []string
+	includesTemplatePathname := path.Join(projectRoot, "templates/includes")
+	err := filepath.Walk(includesTemplatePathname, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if filepath.Ext(path) == ".html" {
+			templateFiles = append(templateFiles, path)
+		}
+
+		return nil
+	})
+
+	return templateFiles, err
+}
+
+// newTemplateRegistry parses base.html together with all includes.
+func newTemplateRegistry(projectRoot string, filters template.FuncMap) (*TemplateRegistry, error) {
+	includes, err := includeTemplateList(projectRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	templates := append([]string{path.Join(projectRoot, "templates/base.html")}, includes...)
+	base, err := template.New("base.html").Funcs(filters).ParseFiles(templates...)
+	if err != nil {
+		return nil, err
+	}
+
+	// ParseFiles names templates after the file, without folders.
+	shared := map[string]string{}
+	for _, file := range templates {
+		rel, err := filepath.Rel(path.Join(projectRoot, "templates"), file)
+		if err != nil {
+			return nil, err
+		}
+		shared[filepath.Base(file)] = filepath.ToSlash(rel)
+	}
+
+	return &TemplateRegistry{
+		projectRoot: projectRoot,
+		base:        base,
+		shared:      shared,
+		sets:        map[string]*template.Template{},
+	}, nil
+}
+
+// Load parses templates/<name> on top of base and includes. Already loaded
+// templates are not parsed again.
+func (r *TemplateRegistry) Load(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.sets[name]; ok {
+		return nil
+	}
+
+	set, err := r.base.Clone()
+	if err != nil {
+		return err
+	}
+
+	set, err = set.ParseFiles(path.Join(r.projectRoot, "templates", name))
+	if err != nil {
+		return err
+	}
+
+	r.sets[name] = set
+	return nil
+}
+
+// Shadows returns the base.html or include template that templates/<name>
+// would replace, since both end up named after their file.
+func (r *TemplateRegistry) Shadows(name string) (string, bool) {
+	shared, ok := r.shared[path.Base(name)]
+	return shared, ok
+}
+
+// Get returns a fresh clone of a loaded template set ready for execution.
+func (r *TemplateRegistry) Get(name string) (*template.Template, error) {
+	r.mu.Lock()
+	set, ok := r.sets[name]
+	r.mu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("template %s is not loaded", name)
+	}
+	return set.Clone()
+}
+
+// Exists reports whether templates/<name> is present in the project.
+func (r *TemplateRegistry) Exists(name string) bool {
+	_, err := os.Stat(path.Join(r.projectRoot, "templates", name))
+	return err == nil
+}
+
+// mediaTypes maps output file extensions to minifier media types. Outputs
+// with other extensions are written as they are.
+var mediaTypes = map[string]string{
+	".html": "text/html",
+	".htm":  "text/html",
+	".xml":  "text/xml",
+	".rss":  "text/xml",
+	".atom": "text/xml",
+	".json": "application/json",
+	".css":  "text/css",
+	".js":   "application/js",
+}
+
+func newMinifier() *minify.M {
+	m := minify.New()
+	m.AddFunc("text/html", mhtml.Minify)
+	m.AddFunc("text/css", mcss.Minify)
+	m.AddFunc("application/js", mjs.Minify)
+	m.AddFunc("text/xml", mxml.Minify)
+	m.AddFunc("application/json", mjson.Minify)
+	return m
+}
+
+// The HTML minifier treats <math> like a block and drops spaces around it,
+// so MathML is swapped for <img> placeholders while minifying.
+var mathElementPattern = regexp.MustCompile(`(?s)<math[\s>].*?</math>`)
+var mathPlaceholderPattern = regexp.MustCompile(`<img data-jbmafp-math="?(\d+)"?>`)
+
+// minifyHTML minifies HTML leaving MathML elements as they are.
+func minifyHTML(m *minify.M, content []byte) ([]byte, error) {
+	var elements [][]byte
+	content = mathElementPattern.ReplaceAllFunc(content, func(element []byte) []byte {
+		elements = append(elements, element)
+		return []byte(fmt.Sprintf(`<img data-jbmafp-math="%d">`, len(elements)-1))
+	})
+
+	minified, err := m.Bytes("text/html", content)
+	if err != nil || len(elements) == 0 {
+		return minified, err
+	}
+
+	return mathPlaceholderPattern.ReplaceAllFunc(minified, func(placeholder []byte) []byte {
+		idx, _ := strconv.Atoi(string(mathPlaceholderPattern.FindSubmatch(placeholder)[1]))
+		return elements[idx]
+	}), nil
+}
+
+// renderTemplate executes the entry template of a loaded template set and,
+// when enabled in config, minifies the result based on the url extension.
+func renderTemplate(registry *TemplateRegistry, name string, entry string, url string, payload Payload) ([]byte, error) {
+	t, err := registry.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, entry, payload); err != nil {
+		return nil, err
+	}
+
+	content := buf.Bytes()
+	if payload.Page.Status != "" {
+		content = injectStatusBanner(content, payload.Page.Status)
+	}
+
+	mediaType, ok := mediaTypes[strings.ToLower(path.Ext(url))]
+	if !payload.Config.Minify || !ok {
+		return content, nil
+	}
+
+	if mediaType == "text/html" {
+		return minifyHTML(newMinifier(), content)
+	}
+	return newMinifier().Bytes(mediaType, content)
+}
+
+// renderHTML renders a page-like template through base.html.
+func renderHTML(registry *TemplateRegistry, name string, payload Payload) ([]byte, error) {
+	return renderTemplate(registry, name, "base.html", ".html", payload)
+}
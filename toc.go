@@ -0,0 +1,99 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"math"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// Average reading speed used for Page.ReadingTime.
+const wordsPerMinute = 200
+
+// TOCEntry is a heading in the page table of contents. ID matches the id
+// attribute goldmark generated for the heading.
+type TOCEntry struct {
+	ID       string      `json:"id"`
+	Title    string      `json:"title"`
+	Level    int         `json:"level"`
+	Children []*TOCEntry `json:"children,omitempty"`
+}
+
+// buildTOC collects headings of a parsed document into a nested list.
+// Skipped levels attach to the closest shallower heading.
+func buildTOC(doc ast.Node, source []byte) []*TOCEntry {
+	var roots []*TOCEntry
+	var stack []*TOCEntry
+
+	ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		heading, ok := node.(*ast.Heading)
+		if !ok || !entering {
+			return ast.WalkContinue, nil
+		}
+
+		entry := &TOCEntry{
+			Title: string(heading.Text(source)),
+			Level: heading.Level,
+		}
+		if id, ok := heading.AttributeString("id"); ok {
+			if b, ok := id.([]byte); ok {
+				entry.ID = string(b)
+			}
+		}
+
+		for len(stack) > 0 && stack[len(stack)-1].Level >= entry.Level {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			roots = append(roots, entry)
+		} else {
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, entry)
+		}
+		stack = append(stack, entry)
+
+		return ast.WalkSkipChildren, nil
+	})
+
+	return roots
+}
+
+// renderTOC renders nested entries as a list of links.
+func renderTOC(entries []*TOCEntry) template.HTML {
+	if len(entries) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	var write func(entries []*TOCEntry)
+	write = func(entries []*TOCEntry) {
+		b.WriteString("<ul>")
+		for _, entry := range entries {
+			b.WriteString(`<li><a href="#`)
+			b.WriteString(html.EscapeString(entry.ID))
+			b.WriteString(`">`)
+			b.WriteString(html.EscapeString(entry.Title))
+			b.WriteString("</a>")
+			if len(entry.Children) > 0 {
+				write(entry.Children)
+			}
+			b.WriteString("</li>")
+		}
+		b.WriteString("</ul>")
+	}
+	write(entries)
+
+	return template.HTML(`<nav class="toc">` + b.String() + `</nav>`)
+}
+
+// countWords counts words in plain text.
+func countWords(text string) int {
+	return len(strings.Fields(html.UnescapeString(text)))
+}
+
+// readingTime returns minutes needed to read words, at least one.
+func readingTime(words int) int {
+	return int(math.Max(1, math.Ceil(float64(words)/wordsPerMinute)))
+}
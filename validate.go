@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	yamlv2 "gopkg.in/yaml.v2"
+)
+
+// FrontMatterError describes a single problem with a page's front matter.
+type FrontMatterError struct {
+	File    string
+	Field   string
+	Message string
+}
+
+func (e FrontMatterError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%s: %s", e.File, e.Message)
+	}
+	return fmt.Sprintf("%s: field %q: %s", e.File, e.Field, e.Message)
+}
+
+// Fields every markdown file must define and the type they must have.
+var requiredFields = []struct {
+	Name string
+	Kind string
+}{
+	{"title", "string"},
+	{"date", "string"},
+	{"type", "string"},
+	{"draft", "bool"},
+}
+
+// Fields that may be left out but must have the right type when present.
+var optionalFields = []struct {
+	Name string
+	Kind string
+}{
+	{"url", "string"},
+	{"slug", "string"},
+	{"summary", "string"},
+	{"tags", "list"},
+	{"lastmod", "string"},
+	{"expires", "string"},
+	{"sitemap", "bool"},
+	{"language", "string"},
+}
+
+// kindOf returns a YAML-ish name of the value's type for error messages.
+func kindOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "nothing"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case int, int64, uint64:
+		return "int"
+	case float64:
+		return "float"
+	case []interface{}:
+		return "list"
+	case map[interface{}]interface{}, map[string]interface{}:
+		return "map"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// relativeFilepath makes file relative to project root for error messages.
+func relativeFilepath(projectRoot string, file string) string {
+	rel, err := filepath.Rel(projectRoot, file)
+	if err != nil {
+		return file
+	}
+	return rel
+}
+
+// validateFrontMatter checks metadata against required fields and returns
+// every problem it finds.
+func validateFrontMatter(file string, metaData map[string]interface{}) []error {
+	var errs []error
+	for _, field := range requiredFields {
+		value, ok := metaData[field.Name]
+		if !ok {
+			errs = append(errs, FrontMatterError{file, field.Name, "missing required field"})
+			continue
+		}
+
+		kind := kindOf(value)
+		if kind != field.Kind {
+			errs = append(errs, FrontMatterError{file, field.Name, fmt.Sprintf("expected %s, got %s", field.Kind, kind)})
+			continue
+		}
+
+		if s, ok := value.(string); ok && s == "" {
+			errs = append(errs, FrontMatterError{file, field.Name, "must not be empty"})
+		}
+	}
+
+	for _, field := range optionalFields {
+		value, ok := metaData[field.Name]
+		if !ok || value == nil {
+			continue
+		}
+
+		kind := kindOf(value)
+		if kind != field.Kind {
+			errs = append(errs, FrontMatterError{file, field.Name, fmt.Sprintf("expected %s, got %s", field.Kind, kind)})
+			continue
+		}
+
+		if items, ok := value.([]interface{}); ok {
+			for idx, item := range items {
+				if itemKind := kindOf(item); itemKind != "string" {
+					errs = append(errs, FrontMatterError{file, field.Name, fmt.Sprintf("expected list of strings, got %s at index %d", itemKind, idx)})
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// Layouts accepted for the `date` field, tried in order. Layouts without
+// a zone are interpreted in the configured default timezone.
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseDate parses a front matter date using any of the accepted layouts.
+func parseDate(value string, loc *time.Location) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q as a date, use e.g. 2006-01-02 or 2006-01-02T15:04:05-07:00", value)
+}
+
+// loadTimezone resolves the configured default timezone, UTC when empty.
+func loadTimezone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// isFrontMatterSeparator reports whether line is a --- line.
+func isFrontMatterSeparator(line []byte) bool {
+	line = bytes.TrimSpace(line)
+	return len(line) >= 3 && len(bytes.Trim(line, "-")) == 0
+}
+
+// parseFrontMatter decodes the YAML between the leading --- lines the same
+// way goldmark-meta does and returns the offset where the markdown body
+// starts. Files without front matter get empty metadata.
+func parseFrontMatter(source []byte) (map[string]interface{}, int, error) {
+	lines := bytes.SplitAfter(source, []byte("\n"))
+	if len(lines) == 0 || !isFrontMatterSeparator(lines[0]) {
+		return map[string]interface{}{}, 0, nil
+	}
+
+	offset := len(lines[0])
+	var yamlSource []byte
+	for _, line := range lines[1:] {
+		offset += len(line)
+		if isFrontMatterSeparator(line) {
+			break
+		}
+		yamlSource = append(yamlSource, line...)
+	}
+
+	metaData := map[string]interface{}{}
+	err := yamlv2.Unmarshal(yamlSource, &metaData)
+	return metaData, offset, err
+}
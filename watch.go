@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Path of the server-sent-events endpoint used for live reload.
+const reloadEndpoint = "/_jbmafp/reload"
+
+// Script injected into every HTML response when watch mode is on.
+const reloadScript = `<script>new EventSource("` + reloadEndpoint + `").addEventListener("reload", function() { location.reload(); });</script>`
+
+// reloadBroker fans out reload events to all connected browser tabs.
+type reloadBroker struct {
+	mu      sync.Mutex
+	clients map[chan struct{}]struct{}
+}
+
+func newReloadBroker() *reloadBroker {
+	return &reloadBroker{clients: map[chan struct{}]struct{}{}}
+}
+
+func (b *reloadBroker) subscribe() chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch := make(chan struct{}, 1)
+	b.clients[ch] = struct{}{}
+	return ch
+}
+
+func (b *reloadBroker) unsubscribe(ch chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.clients, ch)
+}
+
+func (b *reloadBroker) broadcast() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.clients {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// ServeHTTP streams reload events to the browser.
+func (b *reloadBroker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ch := b.subscribe()
+	defer b.unsubscribe(ch)
+
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ch:
+			fmt.Fprint(w, "event: reload\ndata: {}\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// injectReload serves HTML files from public with the reload script appended
+// and hands everything else to the regular file server.
+func injectReload(publicRoot string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filename := path.Join(publicRoot, path.Clean("/"+r.URL.Path))
+		if info, err := os.Stat(filename); err == nil && info.IsDir() {
+			// Relative links in index.html resolve against the folder only
+			// with a trailing slash, same as the regular file server.
+			if !strings.HasSuffix(r.URL.Path, "/") {
+				target := r.URL.Path + "/"
+				if r.URL.RawQuery != "" {
+					target += "?" + r.URL.RawQuery
+				}
+				http.Redirect(w, r, target, http.StatusMovedPermanently)
+				return
+			}
+			filename = path.Join(filename, "index.html")
+		}
+
+		if strings.ToLower(filepath.Ext(filename)) != ".html" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if idx := bytes.LastIndex(bytes.ToLower(content), []byte("</body>")); idx >= 0 {
+			content = append(content[:idx:idx], append([]byte(reloadScript), content[idx:]...)...)
+		} else {
+			content = append(content, []byte(reloadScript)...)
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(content)
+	})
+}
+
+// watchedPaths returns files and folders that trigger a rebuild on change.
+func watchedPaths(projectRoot string) []string {
+	return []string{
+		path.Join(projectRoot, "content"),
+		path.Join(projectRoot, "templates"),
+		path.Join(projectRoot, "templates", "includes"),
+		path.Join(projectRoot, "static"),
+		path.Join(projectRoot, "assets"),
+		path.Join(projectRoot, "stopwords"),
+		path.Join(projectRoot, "config.yaml"),
+	}
+}
+
+// snapshotPaths records modification time and size of every file under the
+// given paths so two snapshots can be compared.
+func snapshotPaths(paths []string) map[string]string {
+	snapshot := map[string]string{}
+	for _, root := range paths {
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if !info.IsDir() {
+				snapshot[path] = fmt.Sprintf("%d:%d", info.ModTime().UnixNano(), info.Size())
+			}
+			return nil
+		})
+	}
+	return snapshot
+}
+
+func snapshotsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// safeBuild runs a build and keeps the server alive if it fails.
+func safeBuild(projectRoot string, options BuildOptions) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("Build failed:", r)
+		}
+	}()
+	if err := buildProject(projectRoot, options); err != nil {
+		log.Println("Build failed:", err)
+	}
+}
+
+// watchProject polls the project for changes, rebuilds and notifies browsers.
+func watchProject(projectRoot string, options BuildOptions, broker *reloadBroker) {
+	paths := watchedPaths(projectRoot)
+	previous := snapshotPaths(paths)
+
+	for {
+		time.Sleep(500 * time.Millisecond)
+
+		current := snapshotPaths(paths)
+		if snapshotsEqual(previous, current) {
+			continue
+		}
+
+		// Wait for editors to finish writing before rebuilding.
+		time.Sleep(100 * time.Millisecond)
+		previous = snapshotPaths(paths)
+
+		log.Println("Change detected, rebuilding...")
+		safeBuild(projectRoot, options)
+		broker.broadcast()
+	}
+}